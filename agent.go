@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Checks whether an agent key matches any of the identities, which may be
+// SHA256 or MD5 fingerprints or key comments.
+func matchesIdentity(key *agent.Key, identities []string) bool {
+	pub, err := ssh.ParsePublicKey(key.Marshal())
+	if err != nil {
+		return false
+	}
+
+	sha := ssh.FingerprintSHA256(pub)
+	md5 := ssh.FingerprintLegacyMD5(pub)
+	for _, id := range identities {
+		if id == sha || id == md5 || "MD5:"+md5 == id || id == key.Comment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wraps an agent so that only some of its identities are visible, and keys
+// cannot be added, removed or locked.  Used to limit what is exposed when
+// forwarding the agent to remote hosts.
+type filteredAgent struct {
+	agent      agent.ExtendedAgent
+	identities []string
+}
+
+// Creates a filteredAgent exposing only keys matching identities
+func newFilteredAgent(upstream agent.ExtendedAgent, identities []string) *filteredAgent {
+	return &filteredAgent{
+		agent:      upstream,
+		identities: identities,
+	}
+}
+
+// Lists the identities that pass the filter
+func (fa *filteredAgent) List() ([]*agent.Key, error) {
+	keys, err := fa.agent.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*agent.Key
+	for _, key := range keys {
+		if matchesIdentity(key, fa.identities) {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+// Checks that the key is one that passes the filter
+func (fa *filteredAgent) allowed(key ssh.PublicKey) error {
+	keys, err := fa.List()
+	if err != nil {
+		return err
+	}
+
+	blob := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Blob, blob) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Key %s is not forwarded", ssh.FingerprintSHA256(key))
+}
+
+func (fa *filteredAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if err := fa.allowed(key); err != nil {
+		return nil, err
+	}
+
+	return fa.agent.Sign(key, data)
+}
+
+func (fa *filteredAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if err := fa.allowed(key); err != nil {
+		return nil, err
+	}
+
+	return fa.agent.SignWithFlags(key, data, flags)
+}
+
+func (fa *filteredAgent) Signers() ([]ssh.Signer, error) {
+	return nil, fmt.Errorf("Signers are not available from a forwarded agent")
+}
+
+func (fa *filteredAgent) Add(key agent.AddedKey) error {
+	return fmt.Errorf("Cannot add keys through a forwarded agent")
+}
+
+func (fa *filteredAgent) Remove(key ssh.PublicKey) error {
+	return fmt.Errorf("Cannot remove keys through a forwarded agent")
+}
+
+func (fa *filteredAgent) RemoveAll() error {
+	return fmt.Errorf("Cannot remove keys through a forwarded agent")
+}
+
+func (fa *filteredAgent) Lock(passphrase []byte) error {
+	return fmt.Errorf("Cannot lock a forwarded agent")
+}
+
+func (fa *filteredAgent) Unlock(passphrase []byte) error {
+	return fmt.Errorf("Cannot unlock a forwarded agent")
+}
+
+func (fa *filteredAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// Signs with an agent key over whichever of a pool of agent connections is
+// free, so that many hosts can authenticate at once without each listing the
+// agent's keys, and without more than a fixed number of signing operations in
+// flight.
+type pooledSigner struct {
+	pub  ssh.PublicKey
+	pool chan agent.ExtendedAgent
+}
+
+// Makes signers for the keys, which sign using the connections in pool
+func newPooledSigners(keys []*agent.Key, pool chan agent.ExtendedAgent) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, key := range keys {
+		pub, err := ssh.ParsePublicKey(key.Marshal())
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, &pooledSigner{pub: pub, pool: pool})
+	}
+
+	return signers, nil
+}
+
+func (signer *pooledSigner) PublicKey() ssh.PublicKey {
+	return signer.pub
+}
+
+func (signer *pooledSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return signer.SignWithAlgorithm(rand, data, "")
+}
+
+// Signs with the requested algorithm, which for RSA keys may be one of the
+// SHA-2 variants.  The agent has its own entropy source, so rand is unused.
+func (signer *pooledSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+
+	conn := <-signer.pool
+	defer func() { signer.pool <- conn }()
+
+	if flags == 0 {
+		return conn.Sign(signer.pub, data)
+	}
+
+	return conn.SignWithFlags(signer.pub, data, flags)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Characters that can't go in the cache file's name
+var agentCacheUnsafe = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// Only one background refresh per run
+var agentCacheRefresh sync.Once
+
+// The GET_AGENTS response for one cluster, as saved between runs
+type agentCacheFile struct {
+	Fetched time.Time            `json:"fetched"`
+	Agents  *MesosAgentsResponse `json:"agents"`
+}
+
+// Gets all agents.  With -agent-cache, a saved response younger than that is
+// used without asking Mesos (and refreshed in the background once it is half
+// that age), and an older one is used if Mesos can't be reached.
+func getAgents(mesos string, msgs *log.Logger) (*MesosAgentsResponse, error) {
+	if flagAgentCache <= 0 {
+		return fetchAgents(mesos, "", msgs)
+	}
+
+	path, err := agentCachePath(mesos)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, cacheErr := readAgentCache(path)
+	if cacheErr == nil {
+		if age := time.Since(cached.Fetched); age < flagAgentCache {
+			if age > flagAgentCache/2 {
+				agentCacheRefresh.Do(func() {
+					go fetchAgents(mesos, path, log.New(ioutil.Discard, "", 0))
+				})
+			}
+
+			return cached.Agents, nil
+		}
+	}
+
+	agents, err := fetchAgents(mesos, path, msgs)
+	if err != nil && cacheErr == nil {
+		msgs.Printf("Using agents cached %s ago: %s", time.Since(cached.Fetched).Round(time.Second), err.Error())
+		return cached.Agents, nil
+	}
+
+	return agents, err
+}
+
+// Gets all agents from Mesos, saving them to the cache at path if it's set
+func fetchAgents(mesos, path string, msgs *log.Logger) (*MesosAgentsResponse, error) {
+	client, err := getMesosClient(mesos, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	agents, err := client.GetAgents()
+	if err != nil || path == "" {
+		return agents, err
+	}
+
+	if err := writeAgentCache(path, &agentCacheFile{time.Now(), agents}); err != nil {
+		msgs.Printf("Failed to save agent cache: %s", err.Error())
+	}
+
+	return agents, nil
+}
+
+// Finds the cache file for the cluster at mesos (or -dcos-url)
+func agentCachePath(mesos string) (string, error) {
+	return clusterFilePath("agents", mesos)
+}
+
+// Finds the file of the specified kind, e.g. "agents", kept between runs for
+// the cluster at mesos (or -dcos-url)
+func clusterFilePath(kind, mesos string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	cluster := mesos
+	if flagDCOSURL != "" {
+		cluster = flagDCOSURL
+	}
+
+	name := kind + "-" + agentCacheUnsafe.ReplaceAllString(cluster, "_") + ".json"
+	return filepath.Join(dir, "mesos-ssh", name), nil
+}
+
+func readAgentCache(path string) (*agentCacheFile, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &agentCacheFile{}
+	if err := json.Unmarshal(contents, cached); err != nil {
+		return nil, err
+	}
+
+	return cached, nil
+}
+
+// Saves the cache, replacing the old file all at once so that other runs
+// never see half of it
+func writeAgentCache(path string, cached *agentCacheFile) error {
+	contents, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(path, contents)
+}
+
+// Writes contents to path through a temporary file, so that other runs see
+// either the old contents or the new ones
+func replaceFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Restarts whichever Mesos agent service the host runs
+const agentRestartScript = `for unit in dcos-mesos-slave dcos-mesos-slave-public mesos-slave mesos-agent; do
+	if systemctl is-active --quiet $unit; then
+		exec systemctl restart $unit
+	fi
+done
+echo "No running Mesos agent service found" >&2
+exit 1`
+
+// How often to check on an agent with the master
+const agentPollInterval = 5 * time.Second
+
+// State for the agent-restart subcommand
+type agentRestarter struct {
+	runner    *Runner
+	mesos     *MesosClient
+	msgs      *log.Logger
+	restart   *SSHCommand
+	drainWait time.Duration
+	wait      time.Duration
+	force     bool
+	status    map[string]string
+}
+
+// Restarts the Mesos agent on each host in turn, checking that it is safe
+// first and that the agent re-registers afterwards.
+func agentRestartMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("agent-restart", flag.ExitOnError)
+	restartCmd := fs.String("restart-cmd", "", "Command that restarts the agent service (default: restart whichever of\n\tdcos-mesos-slave, dcos-mesos-slave-public, mesos-slave or mesos-agent is running)")
+	drainWait := fs.Duration("drain-wait", 0, "Wait up to this long for the agent's tasks to finish before restarting it")
+	wait := fs.Duration("wait", 5*time.Minute, "How long to wait for each agent to re-register")
+	force := fs.Bool("force", false, "Restart agents even if frameworks that don't checkpoint have tasks there")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] agent-restart <spec> [-restart-cmd cmd] [-drain-wait duration] [-wait duration] [-force]", os.Args[0])
+	}
+
+	if *restartCmd == "" {
+		*restartCmd = agentRestartScript
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	client, err := getMesosClient(flagMesos, msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find Mesos: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	restarter := &agentRestarter{
+		runner:    runner,
+		mesos:     client,
+		msgs:      msgs,
+		restart:   NewSSHCommand(*restartCmd, true, true, false, flagTimeout, nil),
+		drainWait: *drainWait,
+		wait:      *wait,
+		force:     *force,
+		status:    make(map[string]string),
+	}
+
+	// One agent at a time, stopping at the first problem
+	failed := false
+	for _, host := range hosts {
+		if failed {
+			restarter.status[host] = "skipped"
+			continue
+		}
+
+		status := restarter.restartAgent(host)
+		msgs.Printf("%s: %s", host, status)
+		restarter.status[host] = status
+		if status != "ok" && !strings.HasPrefix(status, "skipped") {
+			msgs.Printf("Stopping; the remaining agents will not be restarted")
+			failed = true
+		}
+	}
+
+	restarter.printReport()
+	runner.Finish()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Restarts the agent on one host.  Returns its final status.
+func (ar *agentRestarter) restartAgent(host string) string {
+	agent, err := ar.findAgent(host)
+	if err != nil {
+		return "failed: " + err.Error()
+	} else if agent == nil {
+		return "failed: not registered with the master"
+	}
+
+	tasks, risky, err := ar.agentTasks(agent)
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+
+	if len(risky) > 0 {
+		ar.msgs.Printf("WARNING: %s has tasks from frameworks that don't checkpoint, which will be lost: %s", host, strings.Join(risky, ", "))
+		if !ar.force {
+			return "skipped: tasks from frameworks that don't checkpoint (use -force)"
+		}
+	}
+
+	// Wait for the tasks to finish, if asked to
+	if ar.drainWait > 0 && tasks > 0 {
+		ar.msgs.Printf("Waiting for %d tasks on %s to finish", tasks, host)
+		deadline := time.Now().Add(ar.drainWait)
+		for tasks > 0 && time.Now().Before(deadline) {
+			time.Sleep(agentPollInterval)
+			if tasks, _, err = ar.agentTasks(agent); err != nil {
+				return "failed: " + err.Error()
+			}
+		}
+
+		if tasks > 0 {
+			ar.msgs.Printf("%s still has %d tasks; restarting anyway", host, tasks)
+		}
+	}
+
+	registered, reregistered := agent.RegisteredTime, agent.ReregisteredTime
+	result, code := ar.runner.RunOne(context.Background(), host, ar.restart)
+	if result.result != nil {
+		return "restart failed: " + result.result.Error()
+	} else if code != 0 {
+		return fmt.Sprintf("restart failed with code %d", code)
+	}
+
+	// The master records when the agent re-registers, or registers afresh if
+	// it couldn't recover
+	deadline := time.Now().Add(ar.wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(agentPollInterval)
+		agent, err := ar.findAgent(host)
+		if err == nil && agent != nil && agent.Active && (agent.RegisteredTime != registered || agent.ReregisteredTime != reregistered) {
+			return "ok"
+		}
+	}
+
+	return "did not re-register"
+}
+
+// Looks up the agent on a host, as the master currently sees it
+func (ar *agentRestarter) findAgent(host string) (*MesosAgent, error) {
+	ar.mesos.Refresh()
+	agents, err := ar.mesos.GetAgents()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, agent := range agents.Agents {
+		if agent.AgentInfo.Hostname == host {
+			return agent, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Counts the agent's tasks, and lists those whose frameworks don't
+// checkpoint, so would be lost by a restart
+func (ar *agentRestarter) agentTasks(agent *MesosAgent) (int, []string, error) {
+	ar.mesos.Refresh()
+	tasks, err := ar.mesos.GetTasks()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	frameworks, err := ar.mesos.GetFrameworks()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	checkpoint := make(map[string]bool)
+	names := make(map[string]string)
+	for _, framework := range frameworks.Frameworks {
+		id := framework.FrameworkInfo.Id.String()
+		checkpoint[id] = framework.FrameworkInfo.Checkpoint
+		names[id] = framework.FrameworkInfo.Name
+	}
+
+	count := 0
+	var risky []string
+	for _, task := range tasks.Tasks {
+		if task.AgentId.String() != agent.AgentInfo.Id.String() {
+			continue
+		}
+
+		count++
+		if framework := task.FrameworkId.String(); !checkpoint[framework] {
+			risky = append(risky, fmt.Sprintf("%s (%s)", task.Name, names[framework]))
+		}
+	}
+
+	return count, risky, nil
+}
+
+// Prints the final status of every host
+func (ar *agentRestarter) printReport() {
+	var hosts []string
+	for host := range ar.status {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "%s\t%s\n", host, ar.status[host])
+	}
+
+	w.Flush()
+}
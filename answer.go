@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// How much recent output is kept to look for prompts in
+const answerWindow = 1024
+
+// A canned response to a prompt on the remote end
+type Answer struct {
+	Pattern  *regexp.Regexp
+	Response string
+}
+
+// Data type for -answer options
+type AnswerList []*Answer
+
+func (list *AnswerList) String() string {
+	var answers []string
+	for _, answer := range *list {
+		answers = append(answers, answer.Pattern.String()+"="+answer.Response)
+	}
+
+	return strings.Join(answers, "; ")
+}
+
+func (list *AnswerList) Set(s string) error {
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return fmt.Errorf("Answers must look like pattern=response: %s", s)
+	}
+
+	re, err := regexp.Compile(s[:eq])
+	if err != nil {
+		return fmt.Errorf("Invalid answer pattern %s: %s", s[:eq], err.Error())
+	}
+
+	*list = append(*list, &Answer{Pattern: re, Response: s[eq+1:]})
+	return nil
+}
+
+// Forwards all stdout to the host's RemoteIO, writing the response to stdin
+// each time the output shows one of the prompts, then closes stdin.  With no
+// answers stdin is closed straight away, so commands that read it get EOF.
+func (sesh *SSHSession) answerPrompts(stdin io.WriteCloser, stdout io.Reader, answers []*Answer) {
+	if len(answers) == 0 {
+		stdin.Close()
+		io.Copy(&stdoutWriter{sesh.Remote}, stdout)
+		return
+	}
+
+	defer stdin.Close()
+
+	var window []byte
+	sect := make([]byte, 256)
+	for {
+		n, err := stdout.Read(sect)
+		if n > 0 {
+			sesh.Remote.Stdout(sect[:n])
+			window = append(window, sect[:n]...)
+			if len(window) > answerWindow {
+				window = window[len(window)-answerWindow:]
+			}
+
+			for _, answer := range answers {
+				if answer.Pattern.Match(window) {
+					log.Printf("Answering prompt %s on %s", answer.Pattern.String(), sesh.Host)
+					stdin.Write([]byte(answer.Response + "\r"))
+
+					// Don't answer the same prompt twice
+					window = nil
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
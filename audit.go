@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Name of the script audit sends to run the rules
+const auditScriptName = "mesos-ssh-audit.sh"
+
+// Lines the audit script prints around each rule's output
+const (
+	auditRulePrefix = "##mesos-ssh:audit-rule "
+	auditExitPrefix = "##mesos-ssh:audit-exit "
+)
+
+// A command to run on every host and what its output should be.  With
+// neither Expect nor Match, the command passes if it exits with 0.
+type auditRule struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Expect  string `json:"expect"`
+	Match   string `json:"match"`
+
+	match *regexp.Regexp
+}
+
+// What one rule's command did on a host
+type auditOutcome struct {
+	ran    bool
+	output string
+	code   int
+}
+
+// One host's results, or the error that kept the rules from running
+type auditHost struct {
+	host     string
+	err      error
+	outcomes []*auditOutcome
+}
+
+// Runs a set of rules on each host and prints a compliance matrix
+func auditMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	rulesPath := fs.String("rules", "", "File of rules to check, in YAML or JSON")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 || *rulesPath == "" {
+		msgs.Fatalf("Usage: %s [OPTIONS] audit <spec> -rules <file>", os.Args[0])
+	}
+
+	contents, err := ioutil.ReadFile(*rulesPath)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	rules, err := parseAuditRules(contents)
+	if err != nil {
+		msgs.Fatalf("Failed to read %s: %s", *rulesPath, err.Error())
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	compliant, err := runAudit(hosts, rules, msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	} else if !compliant {
+		os.Exit(1)
+	}
+}
+
+// Runs the rules on the hosts and prints the matrix, returning whether every
+// host passed.  It returns before the caller exits, so the script's temporary
+// directory is always removed.
+func runAudit(hosts []string, rules []*auditRule, msgs *log.Logger) (bool, error) {
+	// The rules are sent as a script, so their commands don't need quoting
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		return false, err
+	}
+
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, auditScriptName)
+	if err := ioutil.WriteFile(script, []byte(auditScript(rules)), 0755); err != nil {
+		return false, err
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		return false, err
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+auditScriptName, flagSudo, flagPty, false, flagTimeout, []string{script})
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	var results []*auditHost
+	for _, result := range coll.Results {
+		results = append(results, &auditHost{
+			host:     result.host,
+			err:      result.result,
+			outcomes: parseAuditOutput(result.Stdout(), len(rules)),
+		})
+	}
+
+	compliant := printAudit(os.Stdout, rules, results)
+	runner.Finish()
+	return compliant, nil
+}
+
+// Parses rules from a JSON array, or from a YAML list of flat mappings
+func parseAuditRules(contents []byte) ([]*auditRule, error) {
+	var rules []*auditRule
+	if trimmed := strings.TrimSpace(string(contents)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(contents, &rules); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if rules, err = parseAuditYAML(string(contents)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("No rules")
+	}
+
+	for i, rule := range rules {
+		if rule.Command == "" {
+			return nil, fmt.Errorf("Rule %d has no command", i+1)
+		}
+
+		if rule.Name == "" {
+			rule.Name = rule.Command
+		}
+
+		if rule.Match != "" {
+			match, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("Rule %s has a bad match: %s", rule.Name, err.Error())
+			}
+
+			rule.match = match
+		}
+	}
+
+	return rules, nil
+}
+
+// Parses the subset of YAML that rules need: a list of mappings of strings,
+// with comments on their own lines
+func parseAuditYAML(contents string) ([]*auditRule, error) {
+	var rules []*auditRule
+	var rule *auditRule
+
+	for n, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			rule = &auditRule{}
+			rules = append(rules, rule)
+			if trimmed = strings.TrimSpace(trimmed[1:]); trimmed == "" {
+				continue
+			}
+		}
+
+		colon := strings.Index(trimmed, ":")
+		if rule == nil || colon < 0 {
+			return nil, fmt.Errorf("Line %d: expected a list of rules", n+1)
+		}
+
+		value, err := unquoteYAML(strings.TrimSpace(trimmed[colon+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", n+1, err.Error())
+		}
+
+		switch key := strings.TrimSpace(trimmed[:colon]); key {
+		case "name":
+			rule.Name = value
+		case "command":
+			rule.Command = value
+		case "expect":
+			rule.Expect = value
+		case "match":
+			rule.Match = value
+		default:
+			return nil, fmt.Errorf("Line %d: unknown key %s", n+1, key)
+		}
+	}
+
+	return rules, nil
+}
+
+// Removes YAML quotes from a scalar, if it has them
+func unquoteYAML(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return strconv.Unquote(value)
+	}
+
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+
+	return value, nil
+}
+
+// Makes the script that runs every rule, marking where each one's output
+// starts and ends
+func auditScript(rules []*auditRule) string {
+	var buf strings.Builder
+	for i, rule := range rules {
+		buf.WriteString("echo '" + auditRulePrefix + strconv.Itoa(i) + "'\n")
+		buf.WriteString("( " + rule.Command + "\n) 2>&1 </dev/null\n")
+		buf.WriteString("printf '\\n" + auditExitPrefix + "%d\\n' $?\n")
+	}
+
+	return buf.String()
+}
+
+// Splits the audit script's output into each rule's output and exit code
+func parseAuditOutput(output string, count int) []*auditOutcome {
+	outcomes := make([]*auditOutcome, count)
+	for i := range outcomes {
+		outcomes[i] = &auditOutcome{code: -1}
+	}
+
+	current := -1
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(output, "\r", "", -1), "\n") {
+		if strings.HasPrefix(line, auditRulePrefix) {
+			if n, err := strconv.Atoi(line[len(auditRulePrefix):]); err == nil && n >= 0 && n < count {
+				current = n
+				lines = nil
+				continue
+			}
+		}
+
+		if current >= 0 && strings.HasPrefix(line, auditExitPrefix) {
+			if code, err := strconv.Atoi(line[len(auditExitPrefix):]); err == nil {
+				outcomes[current] = &auditOutcome{
+					ran:    true,
+					output: strings.TrimSpace(strings.Join(lines, "\n")),
+					code:   code,
+				}
+
+				current = -1
+				continue
+			}
+		}
+
+		if current >= 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return outcomes
+}
+
+// Whether a rule's outcome is what the rule expects
+func (rule *auditRule) passes(outcome *auditOutcome) bool {
+	if !outcome.ran {
+		return false
+	} else if rule.Expect != "" {
+		return outcome.output == rule.Expect
+	} else if rule.match != nil {
+		return rule.match.MatchString(outcome.output)
+	}
+
+	return outcome.code == 0
+}
+
+// Prints the host × rule matrix, counts for each rule and the details of
+// each failure.  Returns whether every host passed every rule.
+func printAudit(out io.Writer, rules []*auditRule, results []*auditHost) bool {
+	sort.Slice(results, func(i, j int) bool { return results[i].host < results[j].host })
+
+	passed := make([]int, len(rules))
+	var failures []string
+	compliant := true
+
+	fmt.Fprintf(out, "\n===== Compliance\n")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	header := []string{"HOST"}
+	for _, rule := range rules {
+		header = append(header, rule.Name)
+	}
+
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, result := range results {
+		row := []string{result.host}
+		if result.err != nil {
+			compliant = false
+			failures = append(failures, fmt.Sprintf("%s: %s", result.host, result.err.Error()))
+			for range rules {
+				row = append(row, "ERROR")
+			}
+
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+			continue
+		}
+
+		for i, rule := range rules {
+			outcome := result.outcomes[i]
+			if rule.passes(outcome) {
+				passed[i]++
+				row = append(row, "ok")
+				continue
+			}
+
+			compliant = false
+			row = append(row, "FAIL")
+			if !outcome.ran {
+				failures = append(failures, fmt.Sprintf("%s: %s: did not run", result.host, rule.Name))
+			} else {
+				failures = append(failures, fmt.Sprintf("%s: %s: got %q (exit %d)", result.host, rule.Name, firstLine(outcome.output), outcome.code))
+			}
+		}
+
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	w.Flush()
+
+	fmt.Fprintf(out, "\n===== Rules\n")
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tPASS\tFAIL")
+	for i, rule := range rules {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", rule.Name, passed[i], len(results)-passed[i])
+	}
+
+	w.Flush()
+
+	if len(failures) > 0 {
+		fmt.Fprintf(out, "\n===== Failures\n")
+		for _, failure := range failures {
+			fmt.Fprintln(out, failure)
+		}
+	}
+
+	return compliant
+}
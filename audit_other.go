@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "log"
+
+// Records a security-relevant event.  There is no syslog on this platform, so
+// this only reaches the debug log.
+func auditLog(msg string) {
+	log.Printf("AUDIT: %s", msg)
+}
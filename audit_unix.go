@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"log"
+	"log/syslog"
+)
+
+// Records a security-relevant event in the system log
+func auditLog(msg string) {
+	writer, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_WARNING, "mesos-ssh")
+	if err != nil {
+		log.Printf("Failed to write audit log entry: %s", err.Error())
+		return
+	}
+
+	defer writer.Close()
+	writer.Warning(msg)
+}
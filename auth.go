@@ -3,9 +3,12 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -16,47 +19,105 @@ import (
 type Auth struct {
 	pw       *passwordMarshaller
 	methods  []ssh.AuthMethod
-	agent    agent.Agent
+	agent    agent.ExtendedAgent
+	forward  agent.Agent
+	key      interface{}
+	keyFile  string
 	password string
+
+	msgs       *log.Logger
+	agentError sync.Once
+
+	// Connections to the agent that are free for signing, and the agent's
+	// keys, listed once and shared by every connection
+	agentPool    chan agent.ExtendedAgent
+	agentKeys    []string
+	signersLock  sync.Mutex
+	signers      []ssh.Signer
+	signersFound bool
 }
 
-// Sets up SSH authentication methods, password input
-func NewAuth(privateKey, passwordFile string, forwardAgent, authWithAgent bool) (*Auth, error) {
-	auth := &Auth{}
+// How NewAuth sets up authentication
+type AuthOptions struct {
+	// Private key file to offer, if any
+	PrivateKey string
+
+	// File holding the password; if empty, the password is prompted for
+	PasswordFile string
+
+	// The agent's socket, or $SSH_AUTH_SOCK if empty
+	AgentSocket string
+
+	// Whether the agent is wanted for forwarding, and for authenticating
+	ForwardAgent bool
+	AgentAuth    bool
+
+	// How many signing requests are sent to the agent at once
+	AgentConcurrency int
+
+	// If non-empty, only the agent keys with these fingerprints or comments
+	// are offered to hosts, so that the right key is reached before sshd's
+	// MaxAuthTries
+	AgentKeys []string
+
+	// Where prompted passwords are looked up and saved, such as the OS
+	// keyring
+	Stores passwordStores
+
+	// How long the password prompt waits, if non-zero
+	PromptTimeout time.Duration
+}
+
+// Sets up SSH authentication methods and password input.  Problems with the
+// agent are written to msgs and it is not used.
+func NewAuth(options *AuthOptions, msgs *log.Logger) (*Auth, error) {
+	auth := &Auth{msgs: msgs, agentKeys: options.AgentKeys}
 
 	// Authenticate with private key?
-	if privateKey != "" {
+	if privateKey := options.PrivateKey; privateKey != "" {
 		contents, err := ioutil.ReadFile(privateKey)
 		if err != nil {
 			return nil, err
 		}
 
-		key, err := ssh.ParsePrivateKey(contents)
+		raw, err := ssh.ParseRawPrivateKey(contents)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ssh.NewSignerFromKey(raw)
 		if err != nil {
 			return nil, err
 		}
 
+		auth.key = raw
+		auth.keyFile = privateKey
 		auth.methods = append(auth.methods, ssh.PublicKeys(key))
 	}
 
 	// Check for an agent, first.
-	if forwardAgent || authWithAgent {
-		authSock := os.Getenv("SSH_AUTH_SOCK")
+	if options.ForwardAgent || options.AgentAuth {
+		authSock := options.AgentSocket
+		if authSock == "" {
+			authSock = os.Getenv("SSH_AUTH_SOCK")
+		}
+
 		if authSock != "" {
 			if conn, err := net.Dial("unix", authSock); err == nil {
 				auth.agent = agent.NewClient(conn)
-				if authWithAgent {
-					auth.methods = append(auth.methods, ssh.PublicKeysCallback(auth.agent.Signers))
+				if options.AgentAuth {
+					auth.agentPool = dialAgentPool(authSock, auth.agent, options.AgentConcurrency)
+					auth.methods = append(auth.methods, ssh.PublicKeysCallback(auth.agentSigners))
 				}
 			} else {
-				return nil, err
+				msgs.Printf("Failed to connect to SSH agent at %s, continuing without it: %s", authSock, err.Error())
 			}
 		}
 	}
 
-	if passwordFile != "" {
-		// Use the contents of the passwordFile as the password
-		pw, err := ioutil.ReadFile(passwordFile)
+	if options.PasswordFile != "" {
+		// Use the contents of the PasswordFile as the password
+		pw, err := ioutil.ReadFile(options.PasswordFile)
 		if err != nil {
 			return nil, err
 		}
@@ -65,7 +126,7 @@ func NewAuth(privateKey, passwordFile string, forwardAgent, authWithAgent bool)
 		auth.methods = append(auth.methods, ssh.Password(auth.password))
 	} else {
 		// Or just prompt for the password
-		auth.pw = newPasswordMarshaller()
+		auth.pw = newPasswordMarshaller(options.Stores, options.PromptTimeout)
 		auth.methods = append(auth.methods, ssh.PasswordCallback(auth.pw.getPassword))
 	}
 
@@ -81,24 +142,219 @@ func (auth *Auth) getPassword() (string, error) {
 	}
 }
 
+// Gets the keys from the agent, listing them only the first time.  If the
+// agent fails, warns once and carries on with the other auth methods.
+func (auth *Auth) agentSigners() ([]ssh.Signer, error) {
+	auth.signersLock.Lock()
+	defer auth.signersLock.Unlock()
+
+	if auth.signersFound {
+		return auth.signers, nil
+	}
+
+	keys, err := auth.agent.List()
+	if err == nil && len(auth.agentKeys) > 0 {
+		keys = auth.offeredKeys(keys)
+	}
+
+	if err == nil {
+		auth.signers, err = newPooledSigners(keys, auth.agentPool)
+	}
+
+	if err != nil {
+		auth.agentError.Do(func() {
+			auth.msgs.Printf("Failed to get keys from SSH agent, continuing without it: %s", err.Error())
+		})
+
+		return nil, nil
+	}
+
+	auth.signersFound = true
+	return auth.signers, nil
+}
+
+// Keeps the agent keys that match -agent-key
+func (auth *Auth) offeredKeys(keys []*agent.Key) []*agent.Key {
+	var offered []*agent.Key
+	for _, key := range keys {
+		if matchesIdentity(key, auth.agentKeys) {
+			offered = append(offered, key)
+		}
+	}
+
+	if len(offered) == 0 {
+		auth.msgs.Printf("None of the SSH agent's %d keys match -agent-key", len(keys))
+	}
+
+	return offered
+}
+
+// Opens up to size connections to the agent at authSock for signing,
+// including first, which is already open.  Stops at the first one that
+// fails, since the agent may limit connections.
+func dialAgentPool(authSock string, first agent.ExtendedAgent, size int) chan agent.ExtendedAgent {
+	if size < 1 {
+		size = 1
+	}
+
+	pool := make(chan agent.ExtendedAgent, size)
+	pool <- first
+	for i := 1; i < size; i++ {
+		conn, err := net.Dial("unix", authSock)
+		if err != nil {
+			log.Printf("Using %d connections to the SSH agent: %s", i, err.Error())
+			break
+		}
+
+		pool <- agent.NewClient(conn)
+	}
+
+	return pool
+}
+
 // Gets AuthMethods for SSH login
 func (auth *Auth) getAuthMethods() []ssh.AuthMethod {
 	return auth.methods
 }
 
+// Gets AuthMethods for one SSH login, and a function to call with how the
+// login went, so that a prompted password is only saved once a host has
+// accepted it
+func (auth *Auth) loginMethods() ([]ssh.AuthMethod, func(err error)) {
+	if auth.pw == nil {
+		return auth.methods, func(error) {}
+	}
+
+	// The password always comes last
+	used := false
+	methods := append([]ssh.AuthMethod{}, auth.methods[:len(auth.methods)-1]...)
+	methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+		used = true
+		return auth.pw.getPassword()
+	}))
+
+	return methods, func(err error) {
+		if !used {
+			return
+		} else if err == nil {
+			auth.pw.accepted()
+		} else if strings.Contains(err.Error(), "unable to authenticate") {
+			auth.pw.rejected()
+		}
+	}
+}
+
+// Records whether sudo accepted the password, which like a login decides
+// whether a prompted password is saved
+func (auth *Auth) sudoResult(accepted bool) {
+	if auth.pw == nil {
+		return
+	} else if accepted {
+		auth.pw.accepted()
+	} else {
+		auth.pw.rejected()
+	}
+}
+
+// Limits agent forwarding to the listed identities (fingerprints or
+// comments).  If lifetime or confirm is set, the private key given to NewAuth
+// is also added to the local agent with those constraints so that it can be
+// forwarded.
+func (auth *Auth) ConstrainForwarding(identities []string, lifetime time.Duration, confirm bool) error {
+	if auth.agent == nil {
+		return fmt.Errorf("No agent available")
+	}
+
+	if auth.key != nil && (lifetime > 0 || confirm) {
+		err := auth.agent.Add(agent.AddedKey{
+			PrivateKey:       auth.key,
+			Comment:          auth.keyFile,
+			LifetimeSecs:     uint32(lifetime.Seconds()),
+			ConfirmBeforeUse: confirm,
+		})
+
+		if err != nil {
+			return fmt.Errorf("Failed to add %s to agent: %s", auth.keyFile, err.Error())
+		}
+
+		if len(identities) > 0 {
+			identities = append(identities, auth.keyFile)
+		}
+	}
+
+	if len(identities) > 0 {
+		auth.forward = newFilteredAgent(auth.agent, identities)
+	}
+
+	return nil
+}
+
 // Initialize SSH agent forwarding on the specified connection
 func (auth *Auth) forwardAgent(connection *ssh.Client) error {
-	if auth.agent != nil {
+	if auth.forward != nil {
+		return agent.ForwardToAgent(connection, auth.forward)
+	} else if auth.agent != nil {
 		return agent.ForwardToAgent(connection, auth.agent)
 	} else {
 		return fmt.Errorf("No agent available")
 	}
 }
 
+// Somewhere a password can be kept between runs
+type passwordStore interface {
+	Get() (string, error)
+	Set(password string) error
+	Forget() error
+}
+
+// Password stores to try in order
+type passwordStores []passwordStore
+
+// Looks up the password in the first store that has it
+func (stores passwordStores) Get() (string, bool) {
+	for _, store := range stores {
+		if password, err := store.Get(); err == nil {
+			return password, true
+		} else {
+			log.Println(err.Error())
+		}
+	}
+
+	return "", false
+}
+
+// Saves the password in every store
+func (stores passwordStores) Set(password string) {
+	for _, store := range stores {
+		if err := store.Set(password); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// Removes the password from every store
+func (stores passwordStores) Forget() {
+	for _, store := range stores {
+		if err := store.Forget(); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
 // Prompts for password the first time it's asked for, returns it each
 // additional time.
 type passwordMarshaller struct {
 	requests chan passwordRequest
+	stores   passwordStores
+	timeout  time.Duration
+
+	// Whether the stores hold the password, whether a host has accepted it,
+	// and whether it was rejected and needs reading again
+	lock      sync.Mutex
+	password  string
+	stored    bool
+	confirmed bool
+	stale     bool
 }
 
 type passwordRequest chan<- *passwordResponse
@@ -107,8 +363,8 @@ type passwordResponse struct {
 	err      error
 }
 
-func newPasswordMarshaller() *passwordMarshaller {
-	marshaller := &passwordMarshaller{make(chan passwordRequest)}
+func newPasswordMarshaller(stores passwordStores, timeout time.Duration) *passwordMarshaller {
+	marshaller := &passwordMarshaller{requests: make(chan passwordRequest), stores: stores, timeout: timeout}
 	go marshaller.run()
 	return marshaller
 }
@@ -122,15 +378,89 @@ func (pw *passwordMarshaller) getPassword() (string, error) {
 }
 
 func (pw *passwordMarshaller) run() {
-	request := <-pw.requests
-	fmt.Printf("Password:")
-	password, err := terminal.ReadPassword(0)
-	fmt.Println()
+	var response *passwordResponse
+	for request := range pw.requests {
+		pw.lock.Lock()
+		stale := pw.stale
+		pw.stale = false
+		pw.lock.Unlock()
+
+		if response == nil || stale {
+			response = pw.readPassword()
+		}
 
-	response := &passwordResponse{password: string(password), err: err}
-	request <- response
-	for {
-		request := <-pw.requests
 		request <- response
 	}
 }
+
+// Reads the password from the stores if possible, otherwise from the
+// terminal.  A prompted password isn't saved until a host accepts it.
+func (pw *passwordMarshaller) readPassword() *passwordResponse {
+	if password, ok := pw.stores.Get(); ok {
+		pw.lock.Lock()
+		pw.password = password
+		pw.stored = true
+		pw.lock.Unlock()
+		return &passwordResponse{password: password}
+	}
+
+	password, err := pw.prompt()
+	pw.lock.Lock()
+	pw.password = string(password)
+	pw.lock.Unlock()
+	return &passwordResponse{password: string(password), err: err}
+}
+
+// Records that a host, or sudo there, accepted the password.  The first to
+// do so saves it to the stores.
+func (pw *passwordMarshaller) accepted() {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	pw.confirmed = true
+	if !pw.stored && pw.password != "" {
+		pw.stores.Set(pw.password)
+		pw.stored = true
+	}
+}
+
+// Records that a host, or sudo there, rejected the password.  A saved
+// password that no host has accepted is removed from the stores, so that an
+// outdated one isn't used again, and the operator is asked for it instead.
+func (pw *passwordMarshaller) rejected() {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	if !pw.confirmed && pw.stored {
+		log.Printf("Removing the saved password, since it was rejected")
+		pw.stores.Forget()
+		pw.stored = false
+		pw.stale = true
+	}
+}
+
+// Prompts for the password on the terminal, giving up after the timeout.
+func (pw *passwordMarshaller) prompt() ([]byte, error) {
+	// Save the terminal state, so echo can be restored if the prompt is
+	// abandoned.
+	state, err := terminal.GetState(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if pw.timeout > 0 {
+		timeout = time.After(pw.timeout)
+	}
+
+	// A read abandoned here answers the next prompt, such as a confirm()
+	fmt.Printf("Password:")
+	password, err := stdinLines.read(true, timeout)
+	fmt.Println()
+	if err == errPromptTimeout {
+		terminal.Restore(0, state)
+		return nil, fmt.Errorf("Timed out waiting for password")
+	}
+
+	return []byte(password), err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+)
+
+// Prints the login message the way pam_motd does, for -suppress-banner to
+// recognize
+const motdProbe = "cat /run/motd.dynamic /etc/motd 2>/dev/null; true"
+
+// Holds back the start of a stream while it might be a known prefix, such as
+// a host's MOTD, and drops the prefix if the stream does start with it.
+// Carriage returns are ignored when comparing, since a pty adds them.
+type prefixSkipper struct {
+	prefix  []byte
+	matched int
+	pending []byte
+	done    bool
+}
+
+// Makes a prefixSkipper for prefix.  An empty prefix skips nothing.
+func newPrefixSkipper(prefix string) *prefixSkipper {
+	return &prefixSkipper{
+		prefix: []byte(strings.Replace(prefix, "\r", "", -1)),
+		done:   strings.TrimSpace(prefix) == "",
+	}
+}
+
+// Takes the next chunk of the stream, and returns what can be passed on
+func (skip *prefixSkipper) filter(data []byte) []byte {
+	if skip.done {
+		return data
+	}
+
+	for i, c := range data {
+		if c == '\r' {
+			continue
+		}
+
+		if c != skip.prefix[skip.matched] {
+			// Not the prefix after all
+			skip.done = true
+			return append(skip.pending, data...)
+		}
+
+		if skip.matched++; skip.matched == len(skip.prefix) {
+			skip.done = true
+			skip.pending = nil
+			return bytes.TrimLeft(data[i+1:], "\r")
+		}
+	}
+
+	skip.pending = append(skip.pending, data...)
+	return nil
+}
+
+// Returns whatever is still held back, once the stream has ended
+func (skip *prefixSkipper) flush() []byte {
+	skip.done = true
+	pending := skip.pending
+	skip.pending = nil
+	return pending
+}
+
+// Reads the host's MOTD, so that it can be dropped from the command's output.
+// sshd doesn't print it for commands, but shell startup files that do (such
+// as when there's a pty) put it in front of every host's output.
+func skipMOTD(ctx context.Context, transport Transport, remote *RemoteIO) {
+	motd, _ := transport.Output(ctx, motdProbe)
+	remote.skip = newPrefixSkipper(motd)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// Runs chunks through a prefixSkipper and returns everything it passes on
+func skipChunks(prefix string, chunks ...string) string {
+	skip := newPrefixSkipper(prefix)
+	var out []byte
+	for _, chunk := range chunks {
+		out = append(out, skip.filter([]byte(chunk))...)
+	}
+
+	return string(append(out, skip.flush()...))
+}
+
+func TestPrefixSkipper(t *testing.T) {
+	motd := "Welcome to host1\n\nUpdates available\n"
+	tests := []struct {
+		name   string
+		prefix string
+		chunks []string
+		want   string
+	}{
+		{"drops motd", motd, []string{motd + "output\n"}, "output\n"},
+		{"drops motd across chunks", motd, []string{"Welcome ", "to host1\n\nUp", "dates available\nout", "put\n"}, "output\n"},
+		{"ignores carriage returns", motd, []string{"Welcome to host1\r\n\r\nUpdates available\r\noutput\r\n"}, "output\r\n"},
+		{"keeps other output", motd, []string{"Welcome to host2\n", "output\n"}, "Welcome to host2\noutput\n"},
+		{"keeps output shorter than motd", motd, []string{"Welcome to"}, "Welcome to"},
+		{"keeps motd later in output", motd, []string{"output\n" + motd}, "output\n" + motd},
+		{"empty motd", "", []string{"output\n"}, "output\n"},
+		{"blank motd", "\n\n", []string{"\n\noutput\n"}, "\n\noutput\n"},
+		{"only motd", motd, []string{motd}, ""},
+	}
+
+	for _, test := range tests {
+		if got := skipChunks(test.prefix, test.chunks...); got != test.want {
+			t.Errorf("%s: got %q, wanted %q", test.name, got, test.want)
+		}
+	}
+}
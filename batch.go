@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Splits hosts into the groups to run one after another, following -split,
+// -batch-size or -batch-percent, and -batch-by
+func makeBatches(hosts []string, msgs *log.Logger) ([][]string, error) {
+	size, err := batchSize(len(hosts))
+	if err != nil {
+		return nil, err
+	}
+
+	if flagBatchBy == "" {
+		if flagBatchSpread {
+			return nil, fmt.Errorf("-batch-spread needs -batch-by")
+		}
+
+		return splitHosts(hosts, size), nil
+	}
+
+	if !strings.HasPrefix(flagBatchBy, "attribute:") || flagBatchBy == "attribute:" {
+		return nil, fmt.Errorf("Invalid -batch-by %s, expected attribute:NAME", flagBatchBy)
+	}
+
+	if flagBatchSpread && size <= 0 {
+		return nil, fmt.Errorf("-batch-spread needs a group size")
+	}
+
+	domains, err := agentDomains(strings.TrimPrefix(flagBatchBy, "attribute:"), msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchByDomain(hosts, domains, size, flagBatchSpread), nil
+}
+
+// Chooses count hosts at random to run on first, returning them and the
+// rest of the hosts in their original order
+func pickCanaries(hosts []string, count int) (canaries, rest []string) {
+	if count <= 0 {
+		return nil, hosts
+	}
+
+	if count >= len(hosts) {
+		return hosts, nil
+	}
+
+	chosen := make(map[int]bool)
+	for _, i := range rand.New(rand.NewSource(time.Now().UnixNano())).Perm(len(hosts))[:count] {
+		chosen[i] = true
+	}
+
+	for i, host := range hosts {
+		if chosen[i] {
+			canaries = append(canaries, host)
+		} else {
+			rest = append(rest, host)
+		}
+	}
+
+	return canaries, rest
+}
+
+// Works out how many hosts go in each group, out of total
+func batchSize(total int) (int, error) {
+	if flagBatchSize != 0 && flagBatchPercent != 0 {
+		return 0, fmt.Errorf("-batch-size and -batch-percent cannot be used together")
+	}
+
+	if flagBatchSize < 0 {
+		return 0, fmt.Errorf("Invalid -batch-size %d", flagBatchSize)
+	} else if flagBatchSize > 0 {
+		return flagBatchSize, nil
+	}
+
+	if flagBatchPercent < 0 || flagBatchPercent > 100 {
+		return 0, fmt.Errorf("Invalid -batch-percent %g", flagBatchPercent)
+	} else if flagBatchPercent > 0 {
+		// Round up, so there's always at least one host in a group
+		size := int(math.Ceil(float64(total) * flagBatchPercent / 100))
+		if size < 1 {
+			size = 1
+		}
+
+		return size, nil
+	}
+
+	return flagSplit, nil
+}
+
+// Looks up the value of an attribute for every agent, by hostname
+func agentDomains(attribute string, msgs *log.Logger) (map[string]string, error) {
+	agents, err := getAgents(flagMesos, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]string)
+	for _, agent := range agents.Agents {
+		for _, attr := range agent.AgentInfo.Attributes {
+			if attr.Name == attribute {
+				domains[agent.AgentInfo.Hostname] = attr.String()
+			}
+		}
+	}
+
+	return domains, nil
+}
+
+// Splits hosts into groups of at most size hosts (any number if size <= 0),
+// each within a single failure domain.  With spread, each group instead
+// takes hosts from every domain in turn, so that no group holds more of one
+// domain than it must.  Hosts without a domain are treated as one more
+// domain, which comes last.
+func batchByDomain(hosts []string, domains map[string]string, size int, spread bool) [][]string {
+	byDomain := make(map[string][]string)
+	var names []string
+	for _, host := range hosts {
+		domain := domains[host]
+		if _, ok := byDomain[domain]; !ok {
+			names = append(names, domain)
+		}
+
+		byDomain[domain] = append(byDomain[domain], host)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "" || names[j] == "" {
+			return names[j] == ""
+		}
+
+		return names[i] < names[j]
+	})
+
+	if spread {
+		var order []string
+		for len(order) < len(hosts) {
+			for _, name := range names {
+				if len(byDomain[name]) > 0 {
+					order = append(order, byDomain[name][0])
+					byDomain[name] = byDomain[name][1:]
+				}
+			}
+		}
+
+		return splitHosts(order, size)
+	}
+
+	var groups [][]string
+	for _, name := range names {
+		groups = append(groups, splitHosts(byDomain[name], size)...)
+	}
+
+	return groups
+}
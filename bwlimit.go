@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Paces reads so that, between all the readers sharing it, no more than a
+// set number of bytes per second get through.  Safe for concurrent use.
+type rateLimiter struct {
+	rate float64
+
+	// When the bytes let through so far will have been paid for
+	lock sync.Mutex
+	next time.Time
+}
+
+// Creates a rateLimiter for bytesPerSecond, or nil for no limit
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// Waits until n more bytes are allowed through
+func (limiter *rateLimiter) wait(n int) {
+	limiter.lock.Lock()
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+
+	delay := limiter.next.Sub(now)
+	limiter.next = limiter.next.Add(time.Duration(float64(n) / limiter.rate * float64(time.Second)))
+	limiter.lock.Unlock()
+
+	time.Sleep(delay)
+}
+
+// Wraps r so that reads from it are paced by the limiter.  A nil limiter
+// returns r unchanged.
+func (limiter *rateLimiter) Reader(r io.Reader) io.Reader {
+	if limiter == nil {
+		return r
+	}
+
+	return &limitedReader{r: r, limiter: limiter}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (limited *limitedReader) Read(p []byte) (int, error) {
+	// Small reads keep the pace smooth when many hosts share the limit
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+
+	n, err := limited.r.Read(p)
+	if n > 0 {
+		limited.limiter.wait(n)
+	}
+
+	return n, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Nagios plugin states, in increasing order of severity
+const (
+	checkOK = iota
+	checkWarn
+	checkCrit
+)
+
+var checkStateNames = []string{"OK", "WARNING", "CRITICAL"}
+
+// The result of the check on one host
+type checkResult struct {
+	host   string
+	state  int
+	code   int
+	output string
+
+	// Shown in place of the exit code, if set
+	detail string
+}
+
+// Runs a check command and reports Nagios-style, exiting with the worst state
+func checkMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	command := fs.String("cmd", "", "Check command to run on each host")
+	okExit := fs.String("ok-exit", "0", "Comma-separated exit codes that mean OK")
+	warnExit := fs.String("warn-exit", "1", "Comma-separated exit codes that mean WARNING; any other result is CRITICAL")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 || *command == "" {
+		msgs.Fatalf("Usage: %s [OPTIONS] check <spec> -cmd <cmd> [-ok-exit codes] [-warn-exit codes]", os.Args[0])
+	}
+
+	okCodes, err := parseExitCodes(*okExit)
+	if err != nil {
+		msgs.Fatalf("Invalid -ok-exit: %s", err.Error())
+	}
+
+	warnCodes, err := parseExitCodes(*warnExit)
+	if err != nil {
+		msgs.Fatalf("Invalid -warn-exit: %s", err.Error())
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand(*command, flagSudo, flagPty, false, flagTimeout, nil)
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	var results []*checkResult
+	for _, result := range coll.Results {
+		check := &checkResult{
+			host:   result.host,
+			state:  checkCrit,
+			code:   runner.ExitCode(result.host),
+			output: firstLine(result.Stdout()),
+		}
+
+		if result.result != nil {
+			check.output = result.result.Error()
+		} else if okCodes[check.code] {
+			check.state = checkOK
+		} else if warnCodes[check.code] {
+			check.state = checkWarn
+		}
+
+		results = append(results, check)
+	}
+
+	worst := printCheckResults(os.Stdout, results)
+	runner.Finish()
+	os.Exit(worst)
+}
+
+// Parses a comma-separated list of exit codes
+func parseExitCodes(list string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("Bad exit code %s", field)
+		}
+
+		codes[code] = true
+	}
+
+	return codes, nil
+}
+
+// Gets the first non-empty line of output
+func firstLine(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// Prints the cluster rollup followed by each host's state, worst first.
+// Returns the worst state.
+func printCheckResults(out io.Writer, results []*checkResult) int {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].state != results[j].state {
+			return results[i].state > results[j].state
+		}
+
+		return results[i].host < results[j].host
+	})
+
+	worst := checkOK
+	counts := make([]int, len(checkStateNames))
+	for _, result := range results {
+		counts[result.state]++
+		if result.state > worst {
+			worst = result.state
+		}
+	}
+
+	fmt.Fprintf(out, "%s - %d critical, %d warning, %d ok\n", checkStateNames[worst], counts[checkCrit], counts[checkWarn], counts[checkOK])
+	for _, result := range results {
+		detail := result.detail
+		if detail == "" {
+			detail = fmt.Sprintf("exit %d", result.code)
+		}
+
+		fmt.Fprintf(out, "%s %s (%s)", checkStateNames[result.state], result.host, detail)
+		if result.output != "" {
+			fmt.Fprintf(out, ": %s", result.output)
+		}
+
+		fmt.Fprintln(out)
+	}
+
+	return worst
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the script checksum sends to hash the files
+const checksumScriptName = "mesos-ssh-checksum.sh"
+
+// Prints a line of path, sha256 and modification time for each file named
+// in %s, or a placeholder in place of the digest if it can't be hashed
+const checksumScript = `for f in %s; do
+	if [ -f "$f" ] && [ -r "$f" ]; then
+		printf '%%s\t%%s\t%%s\n' "$f" "$(sha256sum < "$f" | cut -d' ' -f1)" "$(date -u -r "$f" +%%Y-%%m-%%dT%%H:%%M:%%SZ)"
+	elif [ -f "$f" ]; then
+		printf '%%s\t(unreadable)\t-\n' "$f"
+	elif [ -e "$f" ]; then
+		printf '%%s\t(not a file)\t-\n' "$f"
+	else
+		printf '%%s\t(missing)\t-\n' "$f"
+	fi
+done
+`
+
+// Reports which hosts have which version of each file, by sha256
+func checksumMain(args []string, msgs *log.Logger) {
+	if len(args) < 2 {
+		msgs.Fatalf("Usage: %s [OPTIONS] checksum <spec> <path>...", os.Args[0])
+	}
+
+	paths := args[1:]
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	// The script is sent as a file, so it doesn't need quoting for sudo
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+	var quoted []string
+	for _, path := range paths {
+		quoted = append(quoted, shellQuote(path))
+	}
+
+	script := filepath.Join(dir, checksumScriptName)
+	contents := fmt.Sprintf(checksumScript, strings.Join(quoted, " "))
+	if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+checksumScriptName, flagSudo, flagPty, false, flagTimeout, []string{script})
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	// For each path, group hosts by digest, noting the range of times the
+	// files were modified
+	digests := make(map[string]map[string][]string)
+	modified := make(map[string]map[string][2]string)
+	for _, path := range paths {
+		digests[path] = make(map[string][]string)
+		modified[path] = make(map[string][2]string)
+	}
+
+	for _, result := range coll.Results {
+		found := make(map[string]bool)
+		for _, line := range strings.Split(strings.Replace(result.Stdout(), "\r", "", -1), "\n") {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 3 || digests[fields[0]] == nil || found[fields[0]] {
+				continue
+			}
+
+			path, digest, mtime := fields[0], fields[1], fields[2]
+			found[path] = true
+			digests[path][digest] = append(digests[path][digest], result.host)
+
+			times, ok := modified[path][digest]
+			if !ok || mtime < times[0] {
+				times[0] = mtime
+			}
+
+			if !ok || mtime > times[1] {
+				times[1] = mtime
+			}
+
+			modified[path][digest] = times
+		}
+
+		// Hosts that couldn't run the script at all
+		for _, path := range paths {
+			if !found[path] {
+				digest := "(failed)"
+				if result.result != nil {
+					digest = "(failed: " + result.result.Error() + ")"
+				}
+
+				digests[path][digest] = append(digests[path][digest], result.host)
+				modified[path][digest] = [2]string{"-", "-"}
+			}
+		}
+	}
+
+	for _, path := range paths {
+		groups := make(map[string][]string)
+		for digest, hosts := range digests[path] {
+			times := modified[path][digest]
+			when := times[0]
+			if times[1] != times[0] {
+				when += " to " + times[1]
+			}
+
+			groups[digest+"\t"+when] = hosts
+		}
+
+		fmt.Printf("\n===== %s\n", path)
+		printHistogram(os.Stdout, "SHA256\tMODIFIED", groups)
+	}
+
+	runner.Finish()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Prints the remote time as fractional seconds since the epoch
+const clockCommand = "date +%s.%N"
+
+// How many times to sample each host's clock; the sample with the shortest
+// round trip is the most accurate
+const clockSamples = 3
+
+// How one host's clock compares to the local one
+type clockOffset struct {
+	host   string
+	offset time.Duration
+	rtt    time.Duration
+	err    error
+}
+
+// Reports hosts whose clocks are too far from the local clock
+func clockMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("clock", flag.ExitOnError)
+	maxOffset := fs.Duration("max-offset", 500*time.Millisecond, "Largest acceptable difference from the local clock")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] clock <spec> [-max-offset duration]", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, flagParallel)
+	offsets := make([]*clockOffset, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			offsets[i] = measureClock(runner, host)
+		}(i, host)
+	}
+
+	wg.Wait()
+
+	bad := printClockOffsets(os.Stdout, offsets, *maxOffset)
+	runner.Finish()
+	if bad > 0 {
+		os.Exit(1)
+	}
+}
+
+// Measures the offset of a host's clock from the local clock, as the remote
+// time minus the local time halfway through the round trip
+func measureClock(runner *Runner, host string) *clockOffset {
+	result := &clockOffset{host: host}
+	ctx, cancel := context.WithTimeout(context.Background(), flagTimeout)
+	defer cancel()
+
+	transport, err := runner.Connect(ctx, host)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	defer transport.Close()
+	for i := 0; i < clockSamples; i++ {
+		start := time.Now()
+		output, err := transport.Output(ctx, clockCommand)
+		rtt := time.Since(start)
+		if err != nil {
+			result.err = err
+			return result
+		}
+
+		remote, err := parseEpoch(strings.TrimSpace(output))
+		if err != nil {
+			result.err = err
+			return result
+		}
+
+		if i == 0 || rtt < result.rtt {
+			result.rtt = rtt
+			result.offset = remote.Sub(start.Add(rtt / 2))
+		}
+	}
+
+	return result
+}
+
+// Parses seconds since the epoch, with or without a fraction
+func parseEpoch(s string) (time.Time, error) {
+	parts := strings.SplitN(s, ".", 2)
+	secs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unexpected output from %s: %s", clockCommand, s)
+	}
+
+	var nsecs int64
+	if len(parts) == 2 {
+		// Without GNU date, %N isn't expanded, so there is no fraction
+		if frac, err := strconv.ParseFloat("0."+parts[1], 64); err == nil {
+			nsecs = int64(frac * 1e9)
+		}
+	}
+
+	return time.Unix(secs, nsecs), nil
+}
+
+// Prints each host's offset, largest first.  Returns how many hosts are
+// outside maxOffset or couldn't be checked.
+func printClockOffsets(out io.Writer, offsets []*clockOffset, maxOffset time.Duration) int {
+	abs := func(d time.Duration) time.Duration {
+		if d < 0 {
+			return -d
+		}
+
+		return d
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		if (offsets[i].err != nil) != (offsets[j].err != nil) {
+			return offsets[i].err != nil
+		}
+
+		return abs(offsets[i].offset) > abs(offsets[j].offset)
+	})
+
+	bad := 0
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tOFFSET\tROUND TRIP\tSTATUS")
+	for _, offset := range offsets {
+		if offset.err != nil {
+			bad++
+			fmt.Fprintf(w, "%s\t-\t-\tfailed: %s\n", offset.host, offset.err.Error())
+			continue
+		}
+
+		status := "ok"
+		if abs(offset.offset) > maxOffset {
+			bad++
+			status = "SKEWED"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", offset.host, offset.offset.Round(time.Millisecond), offset.rtt.Round(time.Millisecond), status)
+	}
+
+	w.Flush()
+	return bad
+}
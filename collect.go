@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often transfers report how much they have copied so far
+const progressInterval = 10 * time.Second
+
+// Archives whichever of the remote glob patterns %s exist to stdout
+const collectScript = `set --; for f in %s; do [ -e "$f" ] && set -- "$@" "$f"; done; [ $# -eq 0 ] || tar -cf - -- "$@" 2>/dev/null`
+
+// Copies the remote files matching patterns into localDir, preserving their
+// paths.  Relative patterns are taken from dir, if set.  The files are
+// streamed straight to disk, so they can be larger than memory.  Returns how
+// many files and bytes were copied.
+func (sesh *SSHSession) collect(dir string, patterns []string, localDir string) (int, int64, error) {
+	log.Printf("Collecting files from %s", sesh.Host)
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	command := fmt.Sprintf(collectScript, strings.Join(patterns, " "))
+	if dir != "" {
+		command = fmt.Sprintf("cd %s && { %s; }", shellQuote(dir), command)
+	}
+
+	if err := session.Start(command); err != nil {
+		return 0, 0, err
+	}
+
+	progress := &progressReader{r: stdout, last: time.Now(), report: func(total int64) {
+		sesh.Remote.Status(fmt.Sprintf("Collected %s so far\n", formatKB(total/1024)))
+	}}
+
+	count, extractErr := extractTar(progress, localDir)
+	if extractErr != nil {
+		// Nothing will read the rest of the archive, so Wait would block
+		session.Close()
+		session.Wait()
+		return count, progress.total, extractErr
+	}
+
+	if err := session.Wait(); err != nil {
+		return count, progress.total, err
+	}
+
+	return count, progress.total, nil
+}
+
+// Counts the bytes read through it, reporting the total now and then
+type progressReader struct {
+	r      io.Reader
+	total  int64
+	last   time.Time
+	report func(total int64)
+}
+
+func (progress *progressReader) Read(p []byte) (int, error) {
+	n, err := progress.r.Read(p)
+	progress.total += int64(n)
+	if time.Since(progress.last) >= progressInterval {
+		progress.last = time.Now()
+		progress.report(progress.total)
+	}
+
+	return n, err
+}
+
+// Extracts the regular files and directories in a tar stream into dir
+func extractTar(r io.Reader, dir string) (int, error) {
+	count := 0
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(strings.TrimLeft(header.Name, "/")))
+		if name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return count, fmt.Errorf("Refusing to extract %s outside of %s", header.Name, dir)
+		}
+
+		path := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return count, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return count, err
+			}
+
+			// Write to the side, so an interrupted transfer doesn't leave
+			// what looks like a whole file
+			partial := path + ".part"
+			file, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return count, err
+			}
+
+			_, err = io.Copy(file, archive)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+
+			if err == nil {
+				err = os.Rename(partial, path)
+			}
+
+			if err != nil {
+				return count, err
+			}
+
+			count++
+		default:
+			log.Printf("Not collecting %s, which is not a regular file", header.Name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ANSI SGR parameters (e.g. "31" for red) to display each stream with
+type StreamStyles map[int]string
+
+// Works out the styles to use for -color mode: "always", "never", or "auto"
+// to use them only when stdout is a terminal.  Returns nil for no styles.
+func NewStreamStyles(mode, stderrStyle, statusStyle string) (StreamStyles, error) {
+	switch mode {
+	case "always":
+	case "never":
+		return nil, nil
+	case "auto":
+		if !terminal.IsTerminal(int(os.Stdout.Fd())) || os.Getenv("TERM") == "dumb" || os.Getenv("NO_COLOR") != "" {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown -color mode %s", mode)
+	}
+
+	return StreamStyles{2: stderrStyle, -1: statusStyle}, nil
+}
+
+// Wraps text in the style for the stream, if it has one
+func (styles StreamStyles) apply(stream int, text string) string {
+	if style := styles[stream]; style != "" {
+		return "\x1b[" + style + "m" + text + "\x1b[0m"
+	}
+
+	return text
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long the cache daemon waits for its first password before giving up
+const credCacheIdle = time.Minute
+
+// Holds the password for one cluster profile in a background process for a
+// while, like sudo's timestamp, so that runs one after another during an
+// incident don't all prompt.
+type CredCache struct {
+	account string
+	ttl     time.Duration
+}
+
+type credCacheRequest struct {
+	Op       string        `json:"op"`
+	Account  string        `json:"account,omitempty"`
+	Password string        `json:"password,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+}
+
+type credCacheResponse struct {
+	Password string `json:"password,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Creates a CredCache entry for the specified user on the specified
+// cluster, which is forgotten ttl after it is saved.
+func NewCredCache(profile, user string, ttl time.Duration) *CredCache {
+	return &CredCache{
+		account: fmt.Sprintf("%s@%s", user, profile),
+		ttl:     ttl,
+	}
+}
+
+// Looks up the cached password
+func (cache *CredCache) Get() (string, error) {
+	resp, err := credCacheSend(&credCacheRequest{Op: "get", Account: cache.account})
+	if err != nil {
+		return "", fmt.Errorf("No cached password for %s", cache.account)
+	}
+
+	return resp.Password, nil
+}
+
+// Removes the cached password, if the cache daemon is running
+func (cache *CredCache) Forget() error {
+	if _, err := credCacheSocket(); err != nil {
+		return err
+	}
+
+	credCacheSend(&credCacheRequest{Op: "forget", Account: cache.account})
+	return nil
+}
+
+// Caches the password, starting the cache daemon if it isn't running
+func (cache *CredCache) Set(password string) error {
+	request := &credCacheRequest{Op: "set", Account: cache.account, Password: password, TTL: cache.ttl}
+	if _, err := credCacheSend(request); err == nil {
+		return nil
+	}
+
+	if err := startCredCache(); err != nil {
+		return fmt.Errorf("Failed to start credential cache: %s", err.Error())
+	}
+
+	// Give it a moment to start listening
+	var err error
+	for i := 0; i < 20; i++ {
+		if _, err = credCacheSend(request); err == nil {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("Failed to cache password: %s", err.Error())
+}
+
+// Finds the socket the cache daemon listens on
+func credCacheSocket() (string, error) {
+	dir, err := privateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "cache.sock"), nil
+}
+
+// Finds (creating if needed) a directory for sockets and locks that only
+// this user can use
+func privateDir() (string, error) {
+	var dir string
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		dir = filepath.Join(runtime, "mesos-ssh")
+	} else {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+
+		dir = filepath.Join(os.TempDir(), "mesos-ssh-"+u.Uid)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("%s is accessible by other users", dir)
+	}
+
+	return dir, nil
+}
+
+// Sends one request to the cache daemon
+func credCacheSend(request *credCacheRequest) (*credCacheResponse, error) {
+	path, err := credCacheSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+
+	resp := &credCacheResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	return resp, nil
+}
+
+// Starts the cache daemon in the background, detached from the terminal
+func startCredCache() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(self, "cache-daemon")
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// Purges the cached passwords, stopping the cache daemon
+func lockMain(args []string, msgs *log.Logger) {
+	if len(args) != 0 {
+		msgs.Fatalf("Usage: %s lock", os.Args[0])
+	}
+
+	if _, err := credCacheSend(&credCacheRequest{Op: "lock"}); err != nil {
+		msgs.Printf("No credential cache running")
+	}
+}
+
+// A cached password, and when it is forgotten
+type credCacheEntry struct {
+	password string
+	expires  time.Time
+}
+
+// Runs the cache daemon, which keeps passwords in memory until they expire
+// and exits once it has none left
+func cacheDaemonMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("cache-daemon", flag.ExitOnError)
+	if rest := parseSubcommandFlags(fs, args); len(rest) != 0 {
+		msgs.Fatalf("Usage: %s cache-daemon", os.Args[0])
+	}
+
+	path, err := credCacheSocket()
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	// Take over a socket left behind by a daemon that died, but not one in use
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		msgs.Fatalf("Credential cache is already running at %s", path)
+	}
+
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	os.Chmod(path, 0600)
+	var lock sync.Mutex
+	entries := make(map[string]*credCacheEntry)
+	idleSince := time.Now()
+	stop := func() {
+		listener.Close()
+		os.Remove(path)
+		os.Exit(0)
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			lock.Lock()
+			for account, entry := range entries {
+				if time.Now().After(entry.expires) {
+					delete(entries, account)
+				}
+			}
+
+			if len(entries) > 0 {
+				idleSince = time.Now()
+			} else if time.Since(idleSince) > credCacheIdle {
+				stop()
+			}
+
+			lock.Unlock()
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		request := &credCacheRequest{}
+		resp := &credCacheResponse{}
+		if err := json.NewDecoder(conn).Decode(request); err != nil {
+			conn.Close()
+			continue
+		}
+
+		lock.Lock()
+		switch request.Op {
+		case "get":
+			if entry, ok := entries[request.Account]; ok && time.Now().Before(entry.expires) {
+				resp.Password = entry.password
+			} else {
+				resp.Error = "Not cached"
+			}
+		case "set":
+			entries[request.Account] = &credCacheEntry{request.Password, time.Now().Add(request.TTL)}
+		case "forget":
+			delete(entries, request.Account)
+		case "lock":
+			json.NewEncoder(conn).Encode(resp)
+			conn.Close()
+			stop()
+		default:
+			resp.Error = fmt.Sprintf("Unknown request %s", request.Op)
+		}
+
+		lock.Unlock()
+		json.NewEncoder(conn).Encode(resp)
+		conn.Close()
+	}
+}
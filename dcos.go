@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Finds the ACS token for DC/OS Admin Router, from $DCOS_ACS_TOKEN or the
+// dcos CLI's configuration for the attached cluster
+func dcosToken() (string, error) {
+	if token := os.Getenv("DCOS_ACS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	path, err := dcosConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	config, err := readDCOSConfig(path)
+	if err != nil {
+		return "", err
+	}
+
+	if token := config["core.dcos_acs_token"]; token != "" {
+		return token, nil
+	}
+
+	return "", fmt.Errorf("No dcos_acs_token in %s; run dcos auth login or set DCOS_ACS_TOKEN", path)
+}
+
+// Finds the dcos CLI's configuration: the attached cluster's dcos.toml under
+// $DCOS_DIR (or ~/.dcos), or the older single dcos.toml there
+func dcosConfigPath() (string, error) {
+	if path := os.Getenv("DCOS_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir := os.Getenv("DCOS_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		dir = filepath.Join(home, ".dcos")
+	}
+
+	clusters, _ := ioutil.ReadDir(filepath.Join(dir, "clusters"))
+	for _, cluster := range clusters {
+		path := filepath.Join(dir, "clusters", cluster.Name())
+		if _, err := os.Stat(filepath.Join(path, "attached")); err == nil {
+			return filepath.Join(path, "dcos.toml"), nil
+		}
+	}
+
+	return filepath.Join(dir, "dcos.toml"), nil
+}
+
+// Reads the simple string settings out of a dcos.toml, keyed by
+// section.name
+func readDCOSConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	config := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(pair[1])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			config[section+"."+strings.TrimSpace(pair[0])] = value[1 : len(value)-1]
+		}
+	}
+
+	return config, scanner.Err()
+}
+
+// Creates a client for the Mesos master behind Admin Router at dcosURL
+func dcosMesosClient(dcosURL string, httpClient *http.Client) (*MesosClient, error) {
+	token, err := dcosToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewMesosClient(strings.TrimRight(dcosURL, "/")+"/mesos", flagMesosRate, httpClient)
+	client.token = token
+	if _, err := client.GetVersion(); err != nil {
+		return nil, fmt.Errorf("Failed to reach Mesos through %s: %s", dcosURL, err.Error())
+	}
+
+	return client, nil
+}
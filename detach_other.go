@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os/exec"
+
+// Does nothing; cmd already outlives the process that starts it
+func detach(cmd *exec.Cmd) {
+}
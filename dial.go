@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Makes the net.Dialer for connections to hosts and jump hosts, following
+// -bind-addr, -keepalive and -dscp
+func newDialer() (*net.Dialer, error) {
+	dialer := &net.Dialer{KeepAlive: flagKeepAlive}
+
+	if flagBindAddr != "" {
+		ip, err := bindAddress(flagBindAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	if flagDSCP != 0 {
+		if flagDSCP < 0 || flagDSCP > 63 {
+			return nil, fmt.Errorf("Invalid -dscp %d, expected 0 to 63", flagDSCP)
+		}
+
+		control, err := tosControl(flagDSCP << 2)
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.Control = control
+	}
+
+	return dialer, nil
+}
+
+// Finds the local address to connect from, given as an IP address or the
+// name of an interface, whose first address is used
+func bindAddress(addr string) (net.IP, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -bind-addr %s: not an IP address or interface", addr)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the addresses of %s: %s", addr, err.Error())
+	}
+
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			return ipnet.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Interface %s has no addresses", addr)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Tools that mesos-ssh relies on being present on remote hosts
+var doctorRemoteTools = []string{"mktemp", "tar", "sha256sum"}
+
+// Results of the doctor subcommand's checks
+type doctor struct {
+	failures int
+}
+
+// Prints a passing check
+func (doc *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+// Prints a problem that may not matter, with advice
+func (doc *doctor) warn(advice, format string, args ...interface{}) {
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+	fmt.Printf("       %s\n", advice)
+}
+
+// Prints a problem that will make runs fail, with advice
+func (doc *doctor) fail(advice, format string, args ...interface{}) {
+	doc.failures++
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+	fmt.Printf("       %s\n", advice)
+}
+
+// Checks that everything a run needs is in place, optionally including a
+// sample host from spec
+func doctorMain(args []string, msgs *log.Logger) {
+	if len(args) > 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] doctor [spec]", os.Args[0])
+	}
+
+	doc := &doctor{}
+	doc.checkDNS()
+	doc.checkMesos()
+	doc.checkAgent()
+	doc.checkKey()
+	doc.checkKnownHosts(msgs)
+
+	if len(args) == 1 {
+		doc.checkHost(args[0], msgs)
+	}
+
+	if doc.failures > 0 {
+		fmt.Printf("\n%d problems found\n", doc.failures)
+		os.Exit(1)
+	}
+}
+
+// Checks the names mesos-ssh uses to find the cluster
+func (doc *doctor) checkDNS() {
+	if flagNoDNS {
+		doc.ok("DNS discovery is disabled")
+		return
+	}
+
+	if _, addrs, err := net.LookupSRV("", "", flagLeaderSRV); err != nil || len(addrs) == 0 {
+		doc.warn(fmt.Sprintf("mesos-ssh will try %s:5050 instead; use -leader-srv if the record has another name", flagLeaderDNS), "Cannot find SRV record %s", flagLeaderSRV)
+	} else {
+		doc.ok("%s points to %s:%d", flagLeaderSRV, addrs[0].Target, addrs[0].Port)
+	}
+
+	for _, name := range []string{flagLeaderDNS, flagMastersDNS} {
+		if addrs, err := net.LookupHost(name); err != nil {
+			doc.warn("Use -mesos to give the leader's address, and a host file instead of 'masters'", "Cannot resolve %s: %s", name, err.Error())
+		} else {
+			doc.ok("%s resolves to %s", name, strings.Join(addrs, ", "))
+		}
+	}
+}
+
+// Checks that the Mesos API can be reached
+func (doc *doctor) checkMesos() {
+	client, err := getMesosClient(flagMesos, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		doc.fail("Check -mesos, and that this machine can reach the masters", "Cannot reach the Mesos API: %s", err.Error())
+		return
+	}
+
+	version, err := client.GetVersion()
+	if err != nil || version == nil {
+		doc.fail("Check -mesos and any credentials it needs", "Mesos API at %s did not answer: %v", client.endpoint, err)
+		return
+	}
+
+	doc.ok("Mesos %s at %s", version.VersionInfo.Version, client.endpoint)
+	if agents, err := client.GetAgents(); err != nil {
+		doc.fail("Check that the credentials allow listing agents", "Cannot list agents: %s", err.Error())
+	} else {
+		doc.ok("Mesos reports %d agents", len(agents.Agents))
+	}
+}
+
+// Checks the local SSH agent
+func (doc *doctor) checkAgent() {
+	if flagNoAgent {
+		doc.ok("Not using an SSH agent (-no-agent)")
+		return
+	}
+
+	sock := flagAgentSocket
+	if sock == "" {
+		sock = os.Getenv("SSH_AUTH_SOCK")
+	}
+
+	if sock == "" {
+		doc.warn("Start ssh-agent and add your key, or use -key", "No SSH agent: SSH_AUTH_SOCK is not set")
+		return
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		doc.warn("Restart ssh-agent, or use -agent-socket to point at a working one", "Cannot connect to the SSH agent at %s: %s", sock, err.Error())
+		return
+	}
+
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		doc.warn("Restart ssh-agent", "The SSH agent at %s failed to list keys: %s", sock, err.Error())
+	} else if len(keys) == 0 {
+		doc.warn("Add your key with ssh-add", "The SSH agent at %s has no keys", sock)
+	} else {
+		doc.ok("SSH agent at %s has %d keys", sock, len(keys))
+	}
+}
+
+// Checks the -key private key
+func (doc *doctor) checkKey() {
+	if flagKeyfile == "" {
+		return
+	}
+
+	info, err := os.Stat(flagKeyfile)
+	if err != nil {
+		doc.fail("Check the -key path", "Cannot read %s: %s", flagKeyfile, err.Error())
+		return
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		doc.warn(fmt.Sprintf("Run chmod 600 %s", flagKeyfile), "%s can be read by other users (mode %04o)", flagKeyfile, info.Mode().Perm())
+	}
+
+	contents, err := ioutil.ReadFile(flagKeyfile)
+	if err != nil {
+		doc.fail("Check the -key path", "Cannot read %s: %s", flagKeyfile, err.Error())
+		return
+	}
+
+	if _, err := ssh.ParseRawPrivateKey(contents); err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			doc.fail("Add the key to your SSH agent instead of using -key", "%s is protected by a passphrase", flagKeyfile)
+		} else {
+			doc.fail("Check that -key is an OpenSSH or PEM private key", "Cannot parse %s: %s", flagKeyfile, err.Error())
+		}
+
+		return
+	}
+
+	doc.ok("Private key %s is usable", flagKeyfile)
+}
+
+// Checks that host keys can be verified
+func (doc *doctor) checkKnownHosts(msgs *log.Logger) {
+	if flagInsecureKeys || flagKeyPolicy == "insecure" {
+		doc.warn("Use -host-key-policy accept-new or strict instead", "Host keys are not being verified")
+		return
+	}
+
+	if flagKeyPolicy == "accept-new" {
+		doc.ok("Host keys of new hosts will be added to known_hosts")
+		return
+	}
+
+	if _, err := NewHostKeyVerifier(flagKeyPolicy, flagKnownHosts, msgs); err != nil {
+		doc.fail("Populate it with ssh-keyscan, or use -host-key-policy accept-new", "%s", err.Error())
+	} else {
+		doc.ok("known_hosts can be loaded")
+	}
+}
+
+// Connects to the first host of spec and checks what runs there will need
+func (doc *doctor) checkHost(spec string, msgs *log.Logger) {
+	hosts, err := GetHosts(flagMesos, spec, msgs)
+	if err != nil || len(hosts) == 0 {
+		doc.fail("Check the host spec", "Cannot find hosts for %s: %v", spec, err)
+		return
+	}
+
+	host := hosts[0]
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		doc.fail("Fix the options above", "%s", err.Error())
+		return
+	}
+
+	defer runner.Finish()
+
+	ctx, cancel := context.WithTimeout(context.Background(), flagTimeout)
+	defer cancel()
+
+	transport, err := runner.Connect(ctx, host)
+	if err != nil {
+		doc.fail("Check -user, -port, the key and known_hosts", "Cannot log in to %s as %s: %s", host, flagUser, err.Error())
+		return
+	}
+
+	defer transport.Close()
+	doc.ok("Logged in to %s as %s", host, flagUser)
+
+	for _, tool := range doctorRemoteTools {
+		if _, err := transport.Output(ctx, "command -v "+tool); err != nil {
+			doc.warn("Features that need it, such as -f, -collect or -resume-above, won't work there", "%s is not installed on %s", tool, host)
+		}
+	}
+
+	if sesh, ok := transport.(*SSHSession); ok {
+		if client, err := newSFTPClient(sesh.connection); err == nil {
+			client.Close()
+			doc.ok("%s accepts SFTP for sending files", host)
+		} else if _, err := transport.Output(ctx, "command -v scp"); err != nil {
+			doc.warn("-f and other options that send files won't work there", "%s has neither SFTP nor scp", host)
+		} else {
+			doc.ok("%s has no SFTP, so files will be sent with scp", host)
+		}
+	}
+
+	if _, err := transport.Output(ctx, "sudo -n true"); err == nil {
+		doc.ok("sudo works without a password on %s", host)
+	} else if _, err := transport.Output(ctx, "command -v sudo"); err != nil {
+		doc.warn("-sudo won't work on this host", "sudo is not installed on %s", host)
+	} else {
+		doc.ok("sudo on %s asks for a password, which -sudo will answer", host)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// One line of the -events stream
+type Event struct {
+	Time     string `json:"time"`
+	Host     string `json:"host"`
+	Type     string `json:"type"`
+	Data     string `json:"data,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Writes newline-delimited JSON events as they happen.  Safe for concurrent
+// use.
+type EventLog struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// Opens an EventLog writing to path, or stdout for "-".  An existing file is
+// appended to.
+func NewEventLog(path string) (*EventLog, error) {
+	if path == "-" {
+		return &EventLog{encoder: json.NewEncoder(os.Stdout)}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventLog{
+		encoder: json.NewEncoder(file),
+		closer:  file,
+	}, nil
+}
+
+// Writes an event, filling in the time
+func (events *EventLog) Write(event *Event) {
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	events.lock.Lock()
+	defer events.lock.Unlock()
+	events.encoder.Encode(event)
+}
+
+// Closes the file, if there is one
+func (events *EventLog) Close() error {
+	if events.closer != nil {
+		return events.closer.Close()
+	}
+
+	return nil
+}
+
+// IOCollector that writes events for every RemoteIO, and otherwise leaves
+// them to another collector
+type EventIOCollector struct {
+	IOCollector
+	events *EventLog
+}
+
+// Wraps coll so that its RemoteIO's also write to events
+func NewEventIOCollector(coll IOCollector, events *EventLog) IOCollector {
+	return &EventIOCollector{
+		IOCollector: coll,
+		events:      events,
+	}
+}
+
+// Creates a new RemoteIO for the specified host
+func (coll *EventIOCollector) NewRemote(host string) *RemoteIO {
+	remote := coll.IOCollector.NewRemote(host)
+	remote.events = coll.events
+	return remote
+}
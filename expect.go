@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Outputs bigger than this many lines are reported as different without a diff
+const maxDiffLines = 5000
+
+// IOCollector that compares each host's stdout against the expected output,
+// and only displays the hosts that differ.
+type ExpectIOCollector struct {
+	RegularIOCollector
+	expected []string
+	matched  int
+}
+
+// Makes an ExpectIOCollector that compares against the contents of path
+func NewExpectIOCollector(path string) (*ExpectIOCollector, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExpectIOCollector{
+		RegularIOCollector: RegularIOCollector{
+			results: make(chan *IOResult),
+		},
+		expected: outputLines(string(contents)),
+	}, nil
+}
+
+// Collects output from all RemoteIO's, displaying a diff for each one that
+// doesn't match, then returns
+func (coll *ExpectIOCollector) Read() {
+	for ; coll.read < coll.count; coll.read++ {
+		result := <-coll.results
+		if result.result != nil {
+			fmt.Printf("\n===== %s failed with %s\n", result.host, result.result.Error())
+			continue
+		}
+
+		actual := outputLines(result.Stdout())
+		diff := diffLines(coll.expected, actual)
+		if diff == nil {
+			coll.matched++
+			continue
+		}
+
+		fmt.Printf("\n===== %s differs from expected output\n", result.host)
+		for _, line := range diff {
+			fmt.Println(line)
+		}
+	}
+
+	coll.waitgroup.Wait()
+}
+
+// Reports how many hosts matched
+func (coll *ExpectIOCollector) Finish() {
+	fmt.Printf("\n===== %d of %d hosts matched the expected output\n", coll.matched, coll.count)
+}
+
+// Splits output into lines, ignoring carriage returns and trailing blank lines
+func outputLines(output string) []string {
+	output = strings.TrimRight(strings.Replace(output, "\r", "", -1), "\n")
+	if output == "" {
+		return nil
+	}
+
+	return strings.Split(output, "\n")
+}
+
+// Compares two lists of lines, returning nil if they are the same, or else
+// every line prefixed with " ", "-" (only in expected) or "+" (only in actual)
+func diffLines(expected, actual []string) []string {
+	if len(expected) == len(actual) {
+		same := true
+		for i := range expected {
+			if expected[i] != actual[i] {
+				same = false
+				break
+			}
+		}
+
+		if same {
+			return nil
+		}
+	}
+
+	if len(expected) > maxDiffLines || len(actual) > maxDiffLines {
+		return []string{fmt.Sprintf("(%d lines, expected %d; too long to compare line by line)", len(actual), len(expected))}
+	}
+
+	// Longest common subsequence of the remaining lines
+	lcs := make([][]int, len(expected)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(actual)+1)
+	}
+
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var result []string
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case i < len(expected) && j < len(actual) && expected[i] == actual[j]:
+			result = append(result, " "+expected[i])
+			i++
+			j++
+		case j < len(actual) && (i == len(expected) || lcs[i][j+1] >= lcs[i+1][j]):
+			result = append(result, "+"+actual[j])
+			j++
+		default:
+			result = append(result, "-"+expected[i])
+			i++
+		}
+	}
+
+	return result
+}
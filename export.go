@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output beyond this many bytes per stream is left out of exported results
+const maxExportOutput = 1 << 20
+
+// One host's result, as indexed into Elasticsearch
+type exportDoc struct {
+	Timestamp  time.Time `json:"@timestamp"`
+	RunID      string    `json:"run_id"`
+	Operator   string    `json:"operator"`
+	Command    string    `json:"command"`
+	Host       string    `json:"host"`
+	OS         string    `json:"os,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	DurationMs int64     `json:"duration_ms"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Truncated  bool      `json:"truncated,omitempty"`
+}
+
+// Copies of a host's output for exporting, up to maxExportOutput of each
+// stream
+type exportOutput struct {
+	stdout    bytes.Buffer
+	stderr    bytes.Buffer
+	truncated bool
+}
+
+// Keeps data from a stream, as far as the limit allows
+func (output *exportOutput) add(buf *bytes.Buffer, data []byte) {
+	if room := maxExportOutput - buf.Len(); len(data) > room {
+		data = data[:room]
+		output.truncated = true
+	}
+
+	buf.Write(data)
+}
+
+// Indexes each host's result into an Elasticsearch or OpenSearch index,
+// in bulk once the run is over
+type ESExporter struct {
+	endpoint string
+	index    string
+
+	lock sync.Mutex
+	docs []*exportDoc
+}
+
+// Creates an ESExporter for the cluster at endpoint.  Credentials can be
+// given in the URL.
+func NewESExporter(endpoint, index string) (*ESExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid -es-url %s", endpoint)
+	}
+
+	if index == "" {
+		return nil, fmt.Errorf("-es-index is needed with -es-url")
+	}
+
+	return &ESExporter{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		index:    index,
+	}, nil
+}
+
+// Queues a host's result to be indexed
+func (exporter *ESExporter) Add(doc *exportDoc) {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	exporter.docs = append(exporter.docs, doc)
+}
+
+// Indexes the queued results with the bulk API
+func (exporter *ESExporter) Flush(msgs *log.Logger) {
+	exporter.lock.Lock()
+	docs := exporter.docs
+	exporter.docs = nil
+	exporter.lock.Unlock()
+
+	if len(docs) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, doc := range docs {
+		encoder.Encode(map[string]interface{}{"index": map[string]string{"_index": exporter.index}})
+		encoder.Encode(doc)
+	}
+
+	if err := exporter.send(&body); err != nil {
+		msgs.Printf("Failed to export results to %s: %s", exporter.index, err.Error())
+		return
+	}
+
+	log.Printf("Exported %d results to %s", len(docs), exporter.index)
+}
+
+// Sends a bulk request, checking for documents that failed
+func (exporter *ESExporter) send(body *bytes.Buffer) error {
+	req, err := http.NewRequest("POST", exporter.endpoint+"/_bulk", body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(contents, &result); err != nil {
+		return err
+	}
+
+	if result.Errors {
+		failed := 0
+		var first string
+		for _, item := range result.Items {
+			for _, action := range item {
+				if len(action.Error) > 0 {
+					if failed++; first == "" {
+						first = string(action.Error)
+					}
+				}
+			}
+		}
+
+		return fmt.Errorf("%d results were rejected, the first with %s", failed, first)
+	}
+
+	return nil
+}
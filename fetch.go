@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// An artifact for remote hosts to download themselves
+type FetchURL struct {
+	URL    string
+	Name   string
+	SHA256 string
+}
+
+// Data type for -fetch-url options: URL[#sha256=<hex>]
+type FetchList []*FetchURL
+
+func (list *FetchList) String() string {
+	var urls []string
+	for _, fetch := range *list {
+		urls = append(urls, fetch.URL)
+	}
+
+	return strings.Join(urls, "; ")
+}
+
+func (list *FetchList) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Only http and https URLs can be fetched: %s", s)
+	}
+
+	fetch := &FetchURL{}
+	if u.Fragment != "" {
+		if !strings.HasPrefix(u.Fragment, "sha256=") {
+			return fmt.Errorf("Expected #sha256=<hex> after URL: %s", s)
+		}
+
+		fetch.SHA256 = strings.ToLower(strings.TrimPrefix(u.Fragment, "sha256="))
+		if b, err := hex.DecodeString(fetch.SHA256); err != nil || len(b) != 32 {
+			return fmt.Errorf("Invalid SHA-256 checksum: %s", fetch.SHA256)
+		}
+
+		u.Fragment = ""
+	}
+
+	fetch.URL = u.String()
+	fetch.Name = path.Base(u.Path)
+	if fetch.Name == "/" || fetch.Name == "." {
+		return fmt.Errorf("Cannot determine a file name for %s", s)
+	}
+
+	*list = append(*list, fetch)
+	return nil
+}
+
+// Shell script that downloads the artifact into the current directory with
+// curl or wget, then verifies its checksum if one was given.
+func (fetch *FetchURL) script() string {
+	name := shellQuote(fetch.Name)
+	u := shellQuote(fetch.URL)
+	script := fmt.Sprintf("if command -v curl >/dev/null 2>&1; then curl -fsSL -o %s %s; else wget -q -O %s %s; fi", name, u, name, u)
+	if fetch.SHA256 != "" {
+		script += fmt.Sprintf(" && echo %s | sha256sum -c -", shellQuote(fetch.SHA256+"  "+fetch.Name))
+	}
+
+	return script
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Fields available to the -summary-format template
+type RunSummary struct {
+	Hosts      []*HostRecord
+	Total      int
+	Succeeded  int
+	Failed     int
+	DurationMs int64
+}
+
+// Backslash escapes understood in -format and -summary-format, since they're
+// awkward to type in a shell
+var formatEscapes = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// Parses a -format or -summary-format template
+func parseFormat(name, format string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"quote": shellQuote,
+		"trim":  strings.TrimSpace,
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(formatEscapes.Replace(format))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -%s: %s", name, err.Error())
+	}
+
+	return tmpl, nil
+}
+
+// IOCollector that writes each host's result through a template as it
+// finishes, then optionally a summary of every host through another.
+type TemplateIOCollector struct {
+	RegularIOCollector
+	host    *template.Template
+	summary *template.Template
+
+	// Every host so far, for the summary
+	start   time.Time
+	records *RunSummary
+}
+
+// Makes a TemplateIOCollector.  Either template may be nil to skip that
+// part.
+func NewTemplateIOCollector(host, summary *template.Template) IOCollector {
+	return &TemplateIOCollector{
+		RegularIOCollector: RegularIOCollector{
+			results: make(chan *IOResult),
+		},
+		host:    host,
+		summary: summary,
+		start:   time.Now(),
+		records: &RunSummary{},
+	}
+}
+
+// Collects output from all RemoteIO's and writes each through the host
+// template, then returns
+func (coll *TemplateIOCollector) Read() {
+	summary := coll.records
+	for ; coll.read < coll.count; coll.read++ {
+		record := newHostRecord(<-coll.results)
+		summary.Hosts = append(summary.Hosts, record)
+		if record.ExitCode == 0 && record.Error == "" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+
+		if coll.host != nil {
+			executeFormat(coll.host, record)
+		}
+	}
+
+	coll.waitgroup.Wait()
+}
+
+// Writes the summary of every host through the summary template
+func (coll *TemplateIOCollector) Finish() {
+	if coll.summary == nil {
+		return
+	}
+
+	summary := coll.records
+	summary.Total = coll.count
+	sort.Slice(summary.Hosts, func(i, j int) bool { return summary.Hosts[i].Host < summary.Hosts[j].Host })
+	summary.DurationMs = int64(time.Since(coll.start) / time.Millisecond)
+	executeFormat(coll.summary, summary)
+}
+
+// Writes data through a template to stdout, ending with a newline
+func executeFormat(tmpl *template.Template, data interface{}) {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to expand -%s: %s\n", tmpl.Name(), err.Error())
+		return
+	}
+
+	output := buf.String()
+	if output == "" {
+		return
+	}
+
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+
+	os.Stdout.WriteString(output)
+}
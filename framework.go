@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Lists the frameworks registered with Mesos, or tears one down.  The master
+// to ask can be given ahead of the action, in place of -mesos.
+func frameworkMain(args []string, msgs *log.Logger) {
+	mesos := flagMesos
+	if len(args) > 0 && args[0] != "list" && args[0] != "teardown" {
+		mesos = args[0]
+		args = args[1:]
+	}
+
+	if len(args) == 0 || (args[0] == "list" && len(args) != 1) || (args[0] == "teardown" && len(args) != 2) || (args[0] != "list" && args[0] != "teardown") {
+		msgs.Fatalf("Usage: %s [OPTIONS] framework [master] list|teardown <id>", os.Args[0])
+	}
+
+	client, err := getMesosClient(mesos, msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find Mesos: %s", err.Error())
+	}
+
+	frameworks, err := client.GetFrameworks()
+	if err != nil {
+		msgs.Fatalf("Failed to get frameworks: %s", err.Error())
+	}
+
+	tasks, err := client.GetTasks()
+	if err != nil {
+		msgs.Fatalf("Failed to get tasks: %s", err.Error())
+	}
+
+	counts := countFrameworkTasks(tasks)
+	if args[0] == "list" {
+		printFrameworks(os.Stdout, frameworks, counts)
+		return
+	}
+
+	id := args[1]
+	var found *MesosFramework
+	if frameworks != nil {
+		for _, framework := range frameworks.Frameworks {
+			if framework.FrameworkInfo.Id.Value != nil && *framework.FrameworkInfo.Id.Value == id {
+				found = framework
+			}
+		}
+	}
+
+	if found == nil {
+		msgs.Fatalf("No framework %s is registered", id)
+	} else if !interactive() {
+		msgs.Fatalf("teardown needs a terminal to confirm at")
+	}
+
+	question := fmt.Sprintf("Tear down %s (%s), killing its %d tasks?", found.FrameworkInfo.Name, id, counts[id])
+	if !confirm(question) {
+		msgs.Fatalf("Not confirmed, so nothing was torn down")
+	}
+
+	if err := client.Teardown(id); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	msgs.Printf("Tore down %s (%s)", found.FrameworkInfo.Name, id)
+}
+
+// Counts the tasks each framework has, by framework ID
+func countFrameworkTasks(tasks *MesosTasksResponse) map[string]int {
+	counts := make(map[string]int)
+	if tasks == nil {
+		return counts
+	}
+
+	for _, task := range tasks.Tasks {
+		if task.FrameworkId.Value != nil {
+			counts[*task.FrameworkId.Value]++
+		}
+	}
+
+	return counts
+}
+
+// Prints the frameworks as a table, sorted by name
+func printFrameworks(out io.Writer, frameworks *MesosFrameworksResponse, counts map[string]int) {
+	var list []*MesosFramework
+	if frameworks != nil {
+		list = append(list, frameworks.Frameworks...)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].FrameworkInfo.Name < list[j].FrameworkInfo.Name })
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tROLE\tUSER\tACTIVE\tTASKS\n")
+	for _, framework := range list {
+		info := framework.FrameworkInfo
+		id := "-"
+		if info.Id.Value != nil {
+			id = *info.Id.Value
+		}
+
+		role := info.Role
+		if len(info.Roles) > 0 {
+			role = strings.Join(info.Roles, ",")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%d\n", id, info.Name, role, info.User, framework.Active, counts[id])
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Name of the script grep sends to search the files
+const grepScriptName = "mesos-ssh-grep.sh"
+
+// Searches the paths, naming each file followed by a NUL, then the line
+// number and ":" for matches or "-" for context.  %[1]s holds grep's
+// options, %[2]s the quoted pattern and %[3]s the quoted paths.  Finding
+// nothing isn't a failure.
+const grepScript = `grep -rHnZ %[1]s -e %[2]s -- %[3]s
+[ $? -le 1 ]
+`
+
+// One line of grep output on a host
+type grepLine struct {
+	text  string
+	match bool
+}
+
+// Searches files across the hosts, and reports matches once for all the
+// hosts they were found on
+func grepMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	ignoreCase := fs.Bool("i", false, "Ignore case")
+	extended := fs.Bool("E", false, "Pattern is an extended regular expression")
+	contextLines := fs.Int("context", 0, "Show this many lines around each match")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) < 3 {
+		msgs.Fatalf("Usage: %s [OPTIONS] grep <spec> <pattern> <path>... [-i] [-E] [-context n]", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	options := ""
+	if *ignoreCase {
+		options += " -i"
+	}
+
+	if *extended {
+		options += " -E"
+	}
+
+	if *contextLines > 0 {
+		options += fmt.Sprintf(" -C %d", *contextLines)
+	}
+
+	var paths []string
+	for _, path := range args[2:] {
+		paths = append(paths, shellQuote(path))
+	}
+
+	// The script is sent as a file, so it doesn't need quoting for sudo
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, grepScriptName)
+	contents := fmt.Sprintf(grepScript, options, shellQuote(args[1]), strings.Join(paths, " "))
+	if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+grepScriptName, flagSudo, flagPty, false, flagTimeout, []string{script})
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	// Hosts for each matching file and line, with the host the context was
+	// seen on followed by the context
+	groups := make(map[string][]string)
+	contexts := make(map[string][]string)
+	counts := make(map[string]int)
+	total, failed := 0, 0
+	for _, result := range coll.Results {
+		if result.result != nil {
+			msgs.Printf("Failed on %s: %s", result.host, result.result.Error())
+			failed++
+			continue
+		}
+
+		files := parseGrep(result.Stdout())
+		for file, lines := range files {
+			for number, line := range lines {
+				if !line.match {
+					continue
+				}
+
+				key := file + "\t" + line.text
+				counts[result.host]++
+				total++
+				if found := groups[key]; len(found) > 0 && found[len(found)-1] == result.host {
+					// The same line again, further down the file
+					continue
+				}
+
+				groups[key] = append(groups[key], result.host)
+				if _, ok := contexts[key]; !ok && *contextLines > 0 {
+					contexts[key] = append([]string{result.host}, grepContext(lines, number, *contextLines)...)
+				}
+			}
+		}
+	}
+
+	printGrepCounts(os.Stdout, counts)
+	fmt.Printf("\n%d matches on %d of %d hosts", total, len(counts), len(hosts))
+	if failed > 0 {
+		fmt.Printf(" (%d failed)", failed)
+	}
+
+	fmt.Println()
+	if *contextLines > 0 {
+		printGrepContexts(os.Stdout, groups, contexts)
+	} else if len(groups) > 0 {
+		fmt.Println()
+		printHistogram(os.Stdout, "FILE\tMATCH", groups)
+	}
+
+	runner.Finish()
+}
+
+// Parses the output of grep -HnZ into the lines seen in each file, by line
+// number
+func parseGrep(output string) map[string]map[int]*grepLine {
+	files := make(map[string]map[int]*grepLine)
+	for _, line := range strings.Split(strings.Replace(output, "\r", "", -1), "\n") {
+		nul := strings.IndexByte(line, 0)
+		if nul < 0 {
+			// "--" between groups of context
+			continue
+		}
+
+		file, rest := line[:nul], line[nul+1:]
+		sep := strings.IndexAny(rest, ":-")
+		if sep < 0 {
+			continue
+		}
+
+		number, err := strconv.Atoi(rest[:sep])
+		if err != nil {
+			continue
+		}
+
+		if files[file] == nil {
+			files[file] = make(map[int]*grepLine)
+		}
+
+		files[file][number] = &grepLine{text: rest[sep+1:], match: rest[sep] == ':'}
+	}
+
+	return files
+}
+
+// Gets the lines around a match, marking the matching lines with ">"
+func grepContext(lines map[int]*grepLine, number, around int) []string {
+	var result []string
+	for n := number - around; n <= number+around; n++ {
+		if line, ok := lines[n]; ok {
+			mark := " "
+			if line.match {
+				mark = ">"
+			}
+
+			result = append(result, fmt.Sprintf("%s %d: %s", mark, n, line.text))
+		}
+	}
+
+	return result
+}
+
+// Prints the number of matches on each host, most first
+func printGrepCounts(out io.Writer, counts map[string]int) {
+	var hosts []string
+	for host := range counts {
+		hosts = append(hosts, host)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		if counts[hosts[i]] != counts[hosts[j]] {
+			return counts[hosts[i]] > counts[hosts[j]]
+		}
+
+		return hosts[i] < hosts[j]
+	})
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "HOST\tMATCHES\n")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "%s\t%d\n", host, counts[host])
+	}
+
+	w.Flush()
+}
+
+// Prints each match with its context, found on the most hosts first
+func printGrepContexts(out io.Writer, groups map[string][]string, contexts map[string][]string) {
+	var keys []string
+	for key, hosts := range groups {
+		keys = append(keys, key)
+		sort.Strings(hosts)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		file := strings.SplitN(key, "\t", 2)[0]
+		hosts := groups[key]
+		fmt.Fprintf(out, "\n===== %s on %d hosts (context from %s): %s\n", file, len(hosts), contexts[key][0], strings.Join(hosts, ", "))
+		for _, line := range contexts[key][1:] {
+			fmt.Fprintln(out, line)
+		}
+	}
+}
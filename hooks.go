@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"text/template"
+)
+
+// Runs a local command for each host that fails, as soon as it fails.
+type FailureHook struct {
+	tmpl *template.Template
+	msgs *log.Logger
+	wg   sync.WaitGroup
+}
+
+// Fields available to the -on-failure-exec template
+type FailureInfo struct {
+	Host     shellWord
+	ExitCode int
+	Error    shellWord
+}
+
+// A template field that expands quoted for the local shell, since it may hold
+// anything, such as remote output in an error
+type shellWord string
+
+func (word shellWord) String() string {
+	return shellQuote(string(word))
+}
+
+// Parses a template for a local command whose shellWord fields are quoted.
+// The quoting is for /bin/sh, so it is refused on Windows, where commands
+// run under cmd instead.
+func parseShellTemplate(name string, text string) (*template.Template, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("Commands run through cmd on Windows, which fields can't be quoted for")
+	}
+
+	funcs := template.FuncMap{
+		// The same as the field alone, which is quoted anyway
+		"quote": func(word shellWord) string { return word.String() },
+		"raw":   func(word shellWord) string { return string(word) },
+	}
+
+	return template.New(name).Funcs(funcs).Parse(text)
+}
+
+// Parses the command template.  Template fields are each substituted as a
+// single shell word; {{raw .Error}} substitutes one verbatim.
+func NewFailureHook(cmd string, msgs *log.Logger) (*FailureHook, error) {
+	tmpl, err := parseShellTemplate("on-failure-exec", cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FailureHook{
+		tmpl: tmpl,
+		msgs: msgs,
+	}, nil
+}
+
+// Starts the hook for a failed host in the background.  exitCode is -1 if the
+// command did not run to completion.
+func (hook *FailureHook) Fire(host string, exitCode int, err error) {
+	info := &FailureInfo{
+		Host:     shellWord(host),
+		ExitCode: exitCode,
+	}
+
+	if err != nil {
+		info.Error = shellWord(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := hook.tmpl.Execute(&buf, info); err != nil {
+		hook.msgs.Printf("Failed to expand -on-failure-exec for %s: %s", host, err.Error())
+		return
+	}
+
+	hook.wg.Add(1)
+	go func() {
+		defer hook.wg.Done()
+
+		cmd := shellCommand(buf.String())
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		log.Printf("Running failure hook for %s: %s", host, buf.String())
+		if err := cmd.Run(); err != nil {
+			hook.msgs.Printf("Failure hook for %s failed: %s", host, err.Error())
+		}
+	}()
+}
+
+// Waits for all running hooks to finish
+func (hook *FailureHook) Wait() {
+	hook.wg.Wait()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Checks host keys against a known_hosts file, according to a policy:
+// "strict" rejects unknown and changed keys, "accept-new" adds unknown keys to
+// the file but rejects changed ones, and "insecure" accepts every key.
+// Problems are recorded for the end-of-run summary.
+type HostKeyVerifier struct {
+	policy string
+	path   string
+	known  ssh.HostKeyCallback
+	msgs   *log.Logger
+
+	lock     sync.Mutex
+	accepted map[string]ssh.PublicKey
+	problems map[string]string
+}
+
+// Creates a HostKeyVerifier using the known_hosts file at path, or
+// ~/.ssh/known_hosts if path is empty.  The insecure policy prints a loud
+// warning.
+func NewHostKeyVerifier(policy, path string, msgs *log.Logger) (*HostKeyVerifier, error) {
+	verifier := &HostKeyVerifier{
+		policy:   policy,
+		path:     path,
+		msgs:     msgs,
+		accepted: make(map[string]ssh.PublicKey),
+		problems: make(map[string]string),
+	}
+
+	switch policy {
+	case "insecure":
+		msgs.Println("WARNING: Host key checking is disabled.  Host keys will NOT be verified and")
+		msgs.Println("WARNING: connections are open to man-in-the-middle attacks.")
+		auditLog("host key verification disabled")
+		return verifier, nil
+	case "strict", "accept-new":
+	default:
+		return nil, fmt.Errorf("Unknown host key policy %s", policy)
+	}
+
+	if verifier.path == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		verifier.path = filepath.Join(current.HomeDir, ".ssh", "known_hosts")
+	}
+
+	// New keys will be added to the file, so it's fine if it doesn't exist yet
+	if policy == "accept-new" {
+		if err := os.MkdirAll(filepath.Dir(verifier.path), 0700); err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(verifier.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		f.Close()
+	}
+
+	known, err := knownhosts.New(verifier.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %s (use -host-key-policy accept-new to add new hosts): %s", verifier.path, err.Error())
+	}
+
+	verifier.known = known
+	return verifier, nil
+}
+
+// Checks the key presented by a host.  Used as an ssh.HostKeyCallback.
+func (verifier *HostKeyVerifier) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if verifier.policy == "insecure" {
+		log.Printf("Accepting unverified %s host key %s from %s", key.Type(), ssh.FingerprintSHA256(key), hostname)
+		return nil
+	}
+
+	err := verifier.known(hostname, remote, key)
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if !ok {
+		return err
+	}
+
+	verifier.lock.Lock()
+	defer verifier.lock.Unlock()
+
+	address := knownhosts.Normalize(hostname)
+	if len(keyErr.Want) > 0 {
+		var want []string
+		for _, known := range keyErr.Want {
+			want = append(want, ssh.FingerprintSHA256(known.Key))
+		}
+
+		verifier.problems[hostname] = fmt.Sprintf("CHANGED: got %s %s, %s has %s", key.Type(), ssh.FingerprintSHA256(key), verifier.path, strings.Join(want, ", "))
+		return fmt.Errorf("Host key for %s has changed; possible man-in-the-middle attack", hostname)
+	}
+
+	if verifier.policy == "strict" {
+		verifier.problems[hostname] = fmt.Sprintf("unknown: %s %s not in %s", key.Type(), ssh.FingerprintSHA256(key), verifier.path)
+		return err
+	}
+
+	// Unknown host, so accept and remember it, unless a different key was
+	// already accepted for the same address during this run
+	if accepted, ok := verifier.accepted[address]; ok {
+		if bytes.Equal(accepted.Marshal(), key.Marshal()) {
+			return nil
+		}
+
+		verifier.problems[hostname] = fmt.Sprintf("CHANGED: got %s %s during the run", key.Type(), ssh.FingerprintSHA256(key))
+		return fmt.Errorf("Host key for %s has changed; possible man-in-the-middle attack", hostname)
+	}
+
+	f, err := os.OpenFile(verifier.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{address}, key)); err != nil {
+		return err
+	}
+
+	verifier.accepted[address] = key
+	verifier.problems[hostname] = fmt.Sprintf("new: added %s %s to %s", key.Type(), ssh.FingerprintSHA256(key), verifier.path)
+	return nil
+}
+
+// Prints the hosts with new, unknown or changed keys, if there were any
+func (verifier *HostKeyVerifier) Print(out io.Writer) {
+	verifier.lock.Lock()
+	defer verifier.lock.Unlock()
+
+	if len(verifier.problems) == 0 {
+		return
+	}
+
+	var hosts []string
+	for host := range verifier.problems {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	fmt.Fprintf(out, "\n===== Host keys\n")
+	for _, host := range hosts {
+		fmt.Fprintf(out, "%s: %s\n", host, verifier.problems[host])
+	}
+}
+
+// Collects the server banner and host key observed on each connection
+type HostKeyReport struct {
+	lock    sync.Mutex
+	entries map[string]*HostKeyEntry
+}
+
+// Details of the SSH server on one remote host
+type HostKeyEntry struct {
+	Host        string
+	Banner      string
+	KeyType     string
+	Fingerprint string
+}
+
+// Creates an empty HostKeyReport
+func NewHostKeyReport() *HostKeyReport {
+	return &HostKeyReport{
+		entries: make(map[string]*HostKeyEntry),
+	}
+}
+
+// Gets the entry for host, creating it if necessary.  Must hold the lock.
+func (report *HostKeyReport) entry(host string) *HostKeyEntry {
+	if ent, ok := report.entries[host]; ok {
+		return ent
+	}
+
+	ent := &HostKeyEntry{Host: host}
+	report.entries[host] = ent
+	return ent
+}
+
+// Records the host key presented by host
+func (report *HostKeyReport) recordKey(host string, key ssh.PublicKey) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	ent := report.entry(host)
+	ent.KeyType = key.Type()
+	ent.Fingerprint = ssh.FingerprintSHA256(key)
+}
+
+// Records the server version banner sent by host
+func (report *HostKeyReport) recordBanner(host, banner string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	report.entry(host).Banner = banner
+}
+
+// Writes the report as a table, sorted by host
+func (report *HostKeyReport) Print(out io.Writer) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	var hosts []string
+	for host := range report.entries {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tVERSION\tKEY TYPE\tFINGERPRINT")
+	for _, host := range hosts {
+		ent := report.entries[host]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ent.Host, ent.Banner, ent.KeyType, ent.Fingerprint)
+	}
+
+	w.Flush()
+}
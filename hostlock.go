@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// How often a queued run checks whether a host is free
+const hostLockPoll = time.Second
+
+// One of the -max-per-host slots on a host, held for the length of a
+// command.  Slots are lock files shared by every run by this user on this
+// machine, so overlapping runs can't pile onto the same host.
+type hostLock struct {
+	file *os.File
+}
+
+// Takes a free slot on host, waiting for one unless -host-busy is reject
+func acquireHostLock(ctx context.Context, host string, remote *RemoteIO) (*hostLock, error) {
+	dir, err := privateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir = filepath.Join(dir, "hosts")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	name := agentCacheUnsafe.ReplaceAllString(host, "_")
+	waiting := false
+	for {
+		for slot := 0; slot < flagMaxPerHost; slot++ {
+			path := filepath.Join(dir, name+"."+strconv.Itoa(slot)+".lock")
+			if file, err := lockFile(path); err == nil {
+				return &hostLock{file}, nil
+			} else if err != errLocked {
+				return nil, err
+			}
+		}
+
+		if flagHostBusy == "reject" {
+			return nil, fmt.Errorf("%s already has %d commands running from other runs", host, flagMaxPerHost)
+		}
+
+		if !waiting {
+			remote.Status("Waiting for other runs on this host to finish\n")
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(hostLockPoll):
+		}
+	}
+}
+
+// Frees the slot for other runs
+func (lock *hostLock) Release() {
+	unlockFile(lock.file)
+}
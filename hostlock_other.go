@@ -0,0 +1,29 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Returned by lockFile when another process holds the lock
+var errLocked = errors.New("Locked")
+
+// Takes the lock by creating the file at path, which must not exist.  A
+// lock left by a process that died must be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+
+	return file, err
+}
+
+// Releases a lock taken by lockFile
+func unlockFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Returned by lockFile when another process holds the lock
+var errLocked = errors.New("Locked")
+
+// Takes an exclusive lock on the file at path without waiting.  The lock is
+// dropped if this process dies.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Releases a lock taken by lockFile
+func unlockFile(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	file.Close()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Hosts picked by Mesos agent attribute or by name, such as those that
+// don't need sudo for -no-sudo-on
+type HostRules struct {
+	rules []*hostRule
+
+	// Values of each attribute the rules use, by hostname
+	attributes map[string]map[string]string
+}
+
+// One rule: attribute:NAME=VALUE or match:PATTERN
+type hostRule struct {
+	attribute string
+	value     string
+	match     string
+}
+
+// Parses the rules given to the named flag, and looks up the agent
+// attributes they need
+func NewHostRules(name string, specs []string, msgs *log.Logger) (*HostRules, error) {
+	rules := &HostRules{attributes: make(map[string]map[string]string)}
+	for _, spec := range specs {
+		rule := &hostRule{}
+		if strings.HasPrefix(spec, "match:") {
+			rule.match = strings.TrimPrefix(spec, "match:")
+			if _, err := matchHosts(nil, rule.match); err != nil {
+				return nil, fmt.Errorf("Invalid -%s %s", name, spec)
+			}
+		} else if strings.HasPrefix(spec, "attribute:") && strings.Contains(spec, "=") {
+			pair := strings.SplitN(strings.TrimPrefix(spec, "attribute:"), "=", 2)
+			rule.attribute, rule.value = pair[0], pair[1]
+		} else {
+			return nil, fmt.Errorf("Invalid -%s %s, expected attribute:NAME=VALUE or match:PATTERN", name, spec)
+		}
+
+		if rule.attribute != "" && rules.attributes[rule.attribute] == nil {
+			values, err := agentDomains(rule.attribute, msgs)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to look up attribute %s: %s", rule.attribute, err.Error())
+			}
+
+			rules.attributes[rule.attribute] = values
+		}
+
+		rules.rules = append(rules.rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Checks whether any of the rules picks a host
+func (rules *HostRules) Match(host string) bool {
+	for _, rule := range rules.rules {
+		if rule.attribute != "" {
+			if value, ok := rules.attributes[rule.attribute][host]; ok && value == rule.value {
+				return true
+			}
+		} else if matched, _ := matchHosts([]string{host}, rule.match); len(matched) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
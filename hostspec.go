@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// A host to connect to, given as [user@]host[:port].  The user and port are
+// zero if not given.
+type HostSpec struct {
+	User string
+	Host string
+	Port int
+}
+
+// Parses [user@]host[:port]
+func ParseHostSpec(spec string) (*HostSpec, error) {
+	target := &HostSpec{Host: spec}
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		target.User, target.Host = spec[:at], spec[at+1:]
+	}
+
+	if h, p, err := net.SplitHostPort(target.Host); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("Invalid port in %s", spec)
+		}
+
+		target.Host, target.Port = h, port
+	} else if strings.HasPrefix(target.Host, "[") && strings.HasSuffix(target.Host, "]") {
+		target.Host = target.Host[1 : len(target.Host)-1]
+	}
+
+	if target.Host == "" {
+		return nil, fmt.Errorf("No host in %s", spec)
+	}
+
+	return target, nil
+}
+
+// Gets the host part of a [user@]host[:port] spec, or the whole spec if it
+// doesn't parse
+func specHost(spec string) string {
+	if target, err := ParseHostSpec(spec); err == nil {
+		return target.Host
+	}
+
+	return spec
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The most of a response body that -body-match looks at
+const httpBodyLimit = 1 << 20
+
+// What -url-template is executed with for each host
+type httpTarget struct {
+	Host string
+}
+
+// Checks an HTTP endpoint on each host in parallel, without SSH, and reports
+// like check
+func httpMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("http", flag.ExitOnError)
+	urlTemplate := fs.String("url-template", "", "URL to check on each host, e.g. http://{{.Host}}:5051/health")
+	okStatus := fs.String("ok-status", "200", "Comma-separated HTTP status codes that mean OK")
+	bodyMatch := fs.String("body-match", "", "Regular expression the response body must match")
+	warnLatency := fs.Duration("warn-latency", 0, "Responses slower than this are WARNING (0 for no limit)")
+	timeout := fs.Duration("timeout", 10*time.Second, "Give up on a request after this long")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 || *urlTemplate == "" {
+		msgs.Fatalf("Usage: %s [OPTIONS] http <spec> -url-template <url> [-ok-status codes] [-body-match regex] [-warn-latency duration]", os.Args[0])
+	}
+
+	tmpl, err := template.New("url-template").Parse(*urlTemplate)
+	if err != nil {
+		msgs.Fatalf("Invalid -url-template: %s", err.Error())
+	}
+
+	okCodes, err := parseExitCodes(*okStatus)
+	if err != nil {
+		msgs.Fatalf("Invalid -ok-status: %s", err.Error())
+	}
+
+	var body *regexp.Regexp
+	if *bodyMatch != "" {
+		if body, err = regexp.Compile(*bodyMatch); err != nil {
+			msgs.Fatalf("Invalid -body-match: %s", err.Error())
+		}
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	results := make([]*checkResult, len(hosts))
+	sem := make(chan struct{}, flagParallel)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host string) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = checkHTTP(client, tmpl, host, okCodes, body, *warnLatency)
+		}(i, host)
+	}
+
+	wg.Wait()
+	os.Exit(printCheckResults(os.Stdout, results))
+}
+
+// Requests the URL for one host, and judges the response
+func checkHTTP(client *http.Client, tmpl *template.Template, host string, okCodes map[int]bool, body *regexp.Regexp, warnLatency time.Duration) *checkResult {
+	result := &checkResult{host: host, state: checkCrit, code: -1, detail: "no response"}
+
+	var url bytes.Buffer
+	if err := tmpl.Execute(&url, &httpTarget{Host: host}); err != nil {
+		result.output = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Get(url.String())
+	if err != nil {
+		result.output = err.Error()
+		return result
+	}
+
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpBodyLimit))
+	latency := time.Since(start)
+	result.code = resp.StatusCode
+	result.detail = fmt.Sprintf("HTTP %d in %s", resp.StatusCode, latency.Round(time.Millisecond))
+	if err != nil {
+		result.output = err.Error()
+	} else if !okCodes[resp.StatusCode] {
+		result.output = firstLine(string(contents))
+	} else if body != nil && !body.Match(contents) {
+		result.output = fmt.Sprintf("Body doesn't match %s", body.String())
+	} else if warnLatency > 0 && latency > warnLatency {
+		result.state = checkWarn
+		result.output = fmt.Sprintf("Slower than %s", warnLatency)
+	} else {
+		result.state = checkOK
+	}
+
+	return result
+}
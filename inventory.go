@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Runs a local program that prints the hosts to use, either one per line or
+// as a JSON array of hosts or of objects with a "host" field.
+func getExecHosts(command string) ([]string, error) {
+	var stdout bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	log.Printf("Running host spec command: %s", command)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Host spec command %s failed: %s", command, err.Error())
+	}
+
+	hosts, err := parseHostList(stdout.String())
+	if err != nil {
+		return nil, fmt.Errorf("Bad output from host spec command %s: %s", command, err.Error())
+	}
+
+	return hosts, nil
+}
+
+// Reads the hosts to use from stdin, in any of the forms getExecHosts takes
+func getStdinHosts() ([]string, error) {
+	if interactive() {
+		log.Printf("Reading hosts from stdin, one per line")
+	}
+
+	contents, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := parseHostList(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("Bad host list on stdin: %s", err.Error())
+	}
+
+	return hosts, nil
+}
+
+// Parses a list of hosts, one per line or as JSON
+func parseHostList(output string) ([]string, error) {
+	trimmed := strings.TrimSpace(output)
+	if !strings.HasPrefix(trimmed, "[") {
+		var result []string
+		for _, line := range strings.Split(trimmed, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				result = append(result, line)
+			}
+		}
+
+		return result, nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(trimmed), &entries); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+		var host string
+		if err := json.Unmarshal(entry, &host); err != nil {
+			// Not a string, so an object with metadata
+			var obj struct {
+				Host string `json:"host"`
+			}
+
+			if err := json.Unmarshal(entry, &obj); err != nil {
+				return nil, err
+			}
+
+			host = obj.Host
+		}
+
+		if host == "" {
+			return nil, fmt.Errorf("Entry without a host: %s", string(entry))
+		}
+
+		result = append(result, host)
+	}
+
+	return result, nil
+}
+
+// Keeps the hosts that -match, less those that are excluded by -exclude or
+// -exclude-file, then cuts them down to -limit or -sample hosts
+func filterHosts(hosts []string) ([]string, error) {
+	hosts, err := matchAndExclude(hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	if flagLimit > 0 && flagSample > 0 {
+		return nil, fmt.Errorf("-limit and -sample cannot be used together")
+	} else if flagLimit > 0 && flagLimit < len(hosts) {
+		hosts = hosts[:flagLimit]
+	} else if flagSample > 0 {
+		hosts, _ = pickCanaries(hosts, flagSample)
+	}
+
+	return hosts, nil
+}
+
+// Keeps the hosts that -match, less those that are excluded by -exclude or
+// -exclude-file
+func matchAndExclude(hosts []string) ([]string, error) {
+	hosts, err := matchHosts(hosts, flagMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := flagExclude
+	if flagExcludeFile != "" {
+		contents, err := ioutil.ReadFile(flagExcludeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(contents), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+
+	return excludeHosts(hosts, patterns)
+}
+
+// Keeps the hosts whose whole name matches pattern, either as a regular
+// expression or as a shell glob.  Hosts given as user@host:port are matched
+// by the host alone.  An empty pattern keeps every host.
+func matchHosts(hosts []string, pattern string) ([]string, error) {
+	if pattern == "" {
+		return hosts, nil
+	}
+
+	match, err := hostMatcher(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -match %s", pattern)
+	}
+
+	var matched []string
+	for _, host := range hosts {
+		if match(specHost(host)) {
+			matched = append(matched, host)
+		}
+	}
+
+	return matched, nil
+}
+
+// Drops the hosts whose whole name matches any of the patterns, as for
+// matchHosts
+func excludeHosts(hosts []string, patterns []string) ([]string, error) {
+	var matchers []func(string) bool
+	for _, pattern := range patterns {
+		match, err := hostMatcher(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exclusion %s", pattern)
+		}
+
+		matchers = append(matchers, match)
+	}
+
+	var kept []string
+	for _, host := range hosts {
+		excluded := false
+		for _, match := range matchers {
+			if match(specHost(host)) {
+				excluded = true
+				break
+			}
+		}
+
+		if excluded {
+			log.Printf("Excluding %s", host)
+		} else {
+			kept = append(kept, host)
+		}
+	}
+
+	return kept, nil
+}
+
+// Makes a function that checks whether a whole host name matches pattern,
+// either as a regular expression or as a shell glob
+func hostMatcher(pattern string) (func(string) bool, error) {
+	// Patterns like "*.example.com" are only valid as globs
+	re, _ := regexp.Compile("^(?:" + pattern + ")$")
+	if _, err := path.Match(pattern, ""); err != nil && re == nil {
+		return nil, err
+	}
+
+	return func(host string) bool {
+		globbed, _ := path.Match(pattern, host)
+		return globbed || (re != nil && re.MatchString(host))
+	}, nil
+}
@@ -3,31 +3,73 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Time to wait for remaining IO after process exit.
-const deadline time.Duration = 100 * time.Millisecond
-
-// Top-level IO collector for SSH output
+// Top-level IO collector for SSH output.  A run split into groups reads
+// each group with the same collector, then finishes it once.
 type IOCollector interface {
 	NewRemote(host string) *RemoteIO
+
+	// Collects and displays output from the RemoteIO's created since the
+	// last Read, then returns
 	Read()
+
+	// Displays anything that covers every host, such as a summary, once
+	// the last Read has returned
+	Finish()
 }
 
+// Lines of stdout starting with this are notes for the end-of-run summary
+const notePrefix = "##mesos-ssh:note "
+
 // A single packet of output
 type IOMessage struct {
 	data   string
 	stream int
+
+	// Order the message was produced in, among those from the same host
+	seq uint64
 }
 
-// Exists per host and sends IO to be aggregated back to IOCollector
+// Exists per host and sends IO to be aggregated back to IOCollector.  Done
+// must be called exactly once, after all output has been sent.
 type RemoteIO struct {
+	// Sequence number for the next message.  stdout and stderr are copied
+	// by separate goroutines, so messages can reach the channel out of
+	// order; collectors put them back in order with a messageSequencer.
+	// First, to be 64-bit aligned for atomic access.
+	seq uint64
+
 	host      string
 	collector chan *IOMessage
 	done      chan error
+
+	// Exit code (-1 until the command exits), and when the host's turn
+	// started and finished
+	code     int
+	started  time.Time
+	finished time.Time
+
+	// Notes found in stdout, and the incomplete line being scanned for one
+	notes    []string
+	noteLine bytes.Buffer
+
+	// Where to write events as well, if anywhere
+	events *EventLog
+
+	// Drops the host's MOTD from the start of stdout, with -suppress-banner
+	skip *prefixSkipper
+
+	// Copies of the output, if results are being exported
+	export *exportOutput
+
+	// Holds stdout for the -pipe command, if there is one
+	pipe *stdoutPipe
 }
 
 func NewRemoteIO(host string) *RemoteIO {
@@ -35,38 +77,210 @@ func NewRemoteIO(host string) *RemoteIO {
 		host:      host,
 		collector: make(chan *IOMessage),
 		done:      make(chan error),
+		code:      -1,
+	}
+}
+
+// Indicates that work on the host has started
+func (remote *RemoteIO) Start() {
+	remote.started = time.Now()
+	remote.event(&Event{Type: "start"})
+}
+
+// Indicates that the connection to the host is up
+func (remote *RemoteIO) Connected() {
+	remote.event(&Event{Type: "connect"})
+}
+
+// Writes an event for the host, if events are being logged
+func (remote *RemoteIO) event(event *Event) {
+	if remote.events != nil {
+		event.Host = remote.host
+		remote.events.Write(event)
+	}
+}
+
+// Takes the next sequence number for a message
+func (remote *RemoteIO) nextSeq() uint64 {
+	return atomic.AddUint64(&remote.seq, 1) - 1
+}
+
+// Puts messages from one RemoteIO back in the order they were produced,
+// holding back any that arrive ahead of an earlier one
+type messageSequencer struct {
+	next    uint64
+	pending map[uint64]*IOMessage
+}
+
+// Adds a message, and returns the messages that are now in order
+func (seq *messageSequencer) add(msg *IOMessage) []*IOMessage {
+	if msg.seq != seq.next {
+		if seq.pending == nil {
+			seq.pending = make(map[uint64]*IOMessage)
+		}
+
+		seq.pending[msg.seq] = msg
+		return nil
+	}
+
+	ready := []*IOMessage{msg}
+	seq.next++
+	for {
+		next, ok := seq.pending[seq.next]
+		if !ok {
+			return ready
+		}
+
+		delete(seq.pending, seq.next)
+		ready = append(ready, next)
+		seq.next++
 	}
 }
 
 // Send data to stdout
 func (remote *RemoteIO) Stdout(data []byte) {
+	if remote.skip != nil {
+		if data = remote.skip.filter(data); len(data) == 0 {
+			return
+		}
+	}
+
+	remote.scanNotes(data)
+	if remote.pipe != nil {
+		remote.pipe.buf.Write(data)
+		return
+	}
+
+	remote.sendStdout(data)
+}
+
+// Passes stdout on to the collector, once it has been filtered
+func (remote *RemoteIO) sendStdout(data []byte) {
+	seq := remote.nextSeq()
+	if remote.export != nil {
+		remote.export.add(&remote.export.stdout, data)
+	}
+
+	remote.event(&Event{Type: "stdout", Data: string(data)})
 	remote.collector <- &IOMessage{
 		data:   string(data),
 		stream: 1,
+		seq:    seq,
 	}
 }
 
+// Picks out notes from stdout
+func (remote *RemoteIO) scanNotes(data []byte) {
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			remote.noteLine.Write(data)
+			return
+		}
+
+		remote.noteLine.Write(data[:nl])
+		line := strings.TrimRight(remote.noteLine.String(), "\r")
+		if strings.HasPrefix(line, notePrefix) {
+			remote.notes = append(remote.notes, strings.TrimSpace(line[len(notePrefix):]))
+		}
+
+		remote.noteLine.Reset()
+		data = data[nl+1:]
+	}
+}
+
+// Gets the notes the host printed.  Only valid once all output has been sent.
+func (remote *RemoteIO) Notes() []string {
+	notes := remote.notes
+	line := strings.TrimRight(remote.noteLine.String(), "\r")
+	if strings.HasPrefix(line, notePrefix) {
+		notes = append(notes, strings.TrimSpace(line[len(notePrefix):]))
+	}
+
+	return notes
+}
+
 // Send data to stderr
 func (remote *RemoteIO) Stderr(data []byte) {
+	seq := remote.nextSeq()
+	if remote.export != nil {
+		remote.export.add(&remote.export.stderr, data)
+	}
+
+	remote.event(&Event{Type: "stderr", Data: string(data)})
 	remote.collector <- &IOMessage{
 		data:   string(data),
 		stream: 2,
+		seq:    seq,
 	}
 }
 
 // Indicates an exit with return code
 func (remote *RemoteIO) Exit(code int) {
+	// All of stdout has arrived
+	remote.endStdout()
+	remote.code = code
+	remote.event(&Event{Type: "exit", ExitCode: &code})
 	remote.collector <- &IOMessage{
 		data:   fmt.Sprintf("Exited with code: %d\n", code),
 		stream: -1,
+		seq:    remote.nextSeq(),
+	}
+}
+
+// Reports something about the connection, other than output
+func (remote *RemoteIO) Status(message string) {
+	remote.event(&Event{Type: "status", Data: message})
+	remote.collector <- &IOMessage{
+		data:   message,
+		stream: -1,
+		seq:    remote.nextSeq(),
 	}
 }
 
 // Indicates the client has terminated
 func (remote *RemoteIO) Done(err error) {
+	remote.endStdout()
+	remote.finished = time.Now()
+	if err != nil {
+		remote.event(&Event{Type: "error", Error: err.Error()})
+	}
+
+	remote.event(&Event{Type: "done"})
 	remote.done <- err
 }
 
+// Sends on any stdout that was held back, once there can be no more
+func (remote *RemoteIO) endStdout() {
+	if remote.skip != nil {
+		// Output that turned out to be shorter than the MOTD
+		if pending := remote.skip.flush(); len(pending) > 0 {
+			remote.skip = nil
+			remote.Stdout(pending)
+		}
+	}
+
+	if remote.pipe != nil {
+		remote.flushPipe()
+	}
+}
+
+// Makes the IOResult for the host, once it is done
+func (remote *RemoteIO) result(msgs []*IOMessage, err error) *IOResult {
+	result := &IOResult{
+		host:   remote.host,
+		msgs:   msgs,
+		result: err,
+		code:   remote.code,
+	}
+
+	if !remote.started.IsZero() {
+		result.duration = remote.finished.Sub(remote.started)
+	}
+
+	return result
+}
+
 // io.Writer to stdout for the specified RemoteIO
 type stdoutWriter struct {
 	remote *RemoteIO
@@ -89,21 +303,31 @@ func (writer *stderrWriter) Write(p []byte) (int, error) {
 
 // IOCollector that displays outputs one-at-a-time after each connection closes.
 type RegularIOCollector struct {
-	results chan *IOResult
-	count   int
+	results   chan *IOResult
+	count     int
+	read      int
+	waitgroup sync.WaitGroup
+
+	// Send remote stderr to local stderr
+	respectStreams bool
 }
 
 // Full output from a remote connection
 type IOResult struct {
-	host   string
-	msgs   []*IOMessage
-	result error
+	host     string
+	msgs     []*IOMessage
+	result   error
+	code     int
+	duration time.Duration
 }
 
-// Makes a RegularIOCollector
-func NewRegularIOCollector() IOCollector {
+// Makes a RegularIOCollector.  If respectStreams is set, each host's stderr
+// goes to the local stderr, a line at a time with the host in front, rather
+// than in a section of its results.
+func NewRegularIOCollector(respectStreams bool) IOCollector {
 	return &RegularIOCollector{
-		results: make(chan *IOResult),
+		results:        make(chan *IOResult),
+		respectStreams: respectStreams,
 	}
 }
 
@@ -111,82 +335,197 @@ func NewRegularIOCollector() IOCollector {
 func (coll *RegularIOCollector) NewRemote(host string) *RemoteIO {
 	remote := NewRemoteIO(host)
 	coll.count++
+	coll.waitgroup.Add(1)
 	go coll.process(remote)
 	return remote
 }
 
 // Collects and displays output from all RemoteIO's, then returns
 func (coll *RegularIOCollector) Read() {
-	recvd := 0
+	for ; coll.read < coll.count; coll.read++ {
+		printResult(<-coll.results, coll.respectStreams)
+	}
 
-	for recvd < coll.count {
-		result := <-coll.results
-		fmt.Printf("\n===== Results from %s\n", result.host)
-		for _, x := range result.msgs {
-			fmt.Printf("%s", x.data)
+	coll.waitgroup.Wait()
+}
+
+// Has nothing more to display
+func (coll *RegularIOCollector) Finish() {
+}
+
+// Displays the full output from one remote connection.  With respectStreams,
+// stderr goes to the local stderr instead.
+func printResult(result *IOResult, respectStreams bool) {
+	if respectStreams {
+		printStderr(result.host, result.Stderr())
+	}
+
+	fmt.Printf("\n===== Results from %s\n", result.host)
+
+	// Start a section each time the stream changes
+	stream := 0
+	newline := true
+	for _, x := range result.msgs {
+		if respectStreams && x.stream == 2 {
+			continue
 		}
-		if result.result != nil {
-			fmt.Printf("==> Failed with %s\n", result.result.Error())
+
+		if x.stream != stream {
+			if !newline {
+				fmt.Println()
+			}
+
+			fmt.Printf("----- %s\n", streamName(x.stream))
+			stream = x.stream
 		}
-		recvd++
+
+		fmt.Printf("%s", x.data)
+		newline = strings.HasSuffix(x.data, "\n")
 	}
 
-	close(coll.results)
+	if !newline {
+		fmt.Println()
+	}
+
+	if result.result != nil {
+		fmt.Printf("==> Failed with %s\n", result.result.Error())
+	}
+}
+
+// Writes a host's stderr to the local stderr, each line tagged with the host
+// as in interleaved output
+func printStderr(host, stderr string) {
+	if stderr == "" {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSuffix(strings.Replace(stderr, "\r\n", "\n", -1), "\n"), "\n")
+	for _, line := range lines {
+		fmt.Fprintf(os.Stderr, "%s [err]: %s\n", host, line)
+	}
+}
+
+// Section heading for a stream in the regular output
+func streamName(stream int) string {
+	switch stream {
+	case 1:
+		return "stdout"
+	case 2:
+		return "stderr"
+	case -1:
+		return "status"
+	default:
+		return fmt.Sprintf("stream %d", stream)
+	}
 }
 
 // Reads output from a single RemoteIO, sends it all back to collector when
 // it is finished.
 func (coll *RegularIOCollector) process(remote *RemoteIO) {
+	defer coll.waitgroup.Done()
+
 	var msgs []*IOMessage
 	var result error
+	var seq messageSequencer
 wait:
 	for {
 		select {
 		case msg := <-remote.collector:
-			msgs = append(msgs, msg)
+			msgs = append(msgs, seq.add(msg)...)
 		case err := <-remote.done:
 			result = err
 			break wait
 		}
 	}
 
-	// Give the data streams some time to finish sending.
-	t := time.NewTimer(deadline)
-wait2:
-	for {
-		select {
-		case msg := <-remote.collector:
-			msgs = append(msgs, msg)
+	coll.results <- remote.result(msgs, result)
+}
 
-			if !t.Stop() {
-				<-t.C
-			}
-			t.Reset(deadline)
-		case <-t.C:
-			break wait2
+// Concatenates everything the host wrote to stdout
+func (result *IOResult) Stdout() string {
+	return result.stream(1)
+}
+
+// Concatenates everything the host wrote to stderr
+func (result *IOResult) Stderr() string {
+	return result.stream(2)
+}
+
+// Concatenates everything the host wrote to a stream
+func (result *IOResult) stream(stream int) string {
+	var buf bytes.Buffer
+	for _, msg := range result.msgs {
+		if msg.stream == stream {
+			buf.WriteString(msg.data)
 		}
 	}
 
-	coll.results <- &IOResult{
-		msgs:   msgs,
-		host:   remote.host,
-		result: result,
+	return buf.String()
+}
+
+// IOCollector that keeps the results from every host for the caller to
+// inspect, rather than displaying them.
+type CaptureIOCollector struct {
+	RegularIOCollector
+	Results []*IOResult
+}
+
+// Makes a CaptureIOCollector
+func NewCaptureIOCollector() *CaptureIOCollector {
+	return &CaptureIOCollector{
+		RegularIOCollector: RegularIOCollector{
+			results: make(chan *IOResult),
+		},
+	}
+}
+
+// Collects the results from all RemoteIO's, then returns
+func (coll *CaptureIOCollector) Read() {
+	for len(coll.Results) < coll.count {
+		coll.Results = append(coll.Results, <-coll.results)
 	}
 
-	close(remote.collector)
-	close(remote.done)
+	coll.waitgroup.Wait()
 }
 
 // IOCollector that interleaves output from many remote hosts as it arrives.
 type InterleavedIOCollector struct {
 	messages  chan *IOMessage
 	waitgroup sync.WaitGroup
+
+	// Emit partial lines as soon as they arrive
+	unbuffered bool
+
+	// If non-zero, emit partial lines that have waited this long
+	flushInterval time.Duration
+
+	// Styles for each stream, if output is colored
+	styles StreamStyles
+
+	// If set, also keep each host's output to display grouped at the end
+	regroup bool
+	lock    sync.Mutex
+	results []*IOResult
+
+	// Send remote stderr to local stderr
+	respectStreams bool
 }
 
-// Creates an InterleavedIOCollector
-func NewInterleavedIOCollector() IOCollector {
+// Creates an InterleavedIOCollector.  By default only whole lines are
+// displayed; if unbuffered is set, every chunk is displayed as it arrives, and
+// if flushInterval is non-zero, partial lines are displayed after waiting that
+// long for the rest of the line.  If regroup is set, each host's output is
+// displayed again, grouped by host, once every host has finished.  Lines are
+// displayed in their stream's style, if styles has one.  If respectStreams is
+// set, lines from the hosts' stderr go to the local stderr.
+func NewInterleavedIOCollector(unbuffered bool, flushInterval time.Duration, regroup bool, styles StreamStyles, respectStreams bool) IOCollector {
 	return &InterleavedIOCollector{
-		messages: make(chan *IOMessage),
+		messages:       make(chan *IOMessage),
+		unbuffered:     unbuffered,
+		flushInterval:  flushInterval,
+		regroup:        regroup,
+		styles:         styles,
+		respectStreams: respectStreams,
 	}
 }
 
@@ -209,15 +548,25 @@ func (coll *InterleavedIOCollector) Read() {
 	for {
 		select {
 		case msg := <-coll.messages:
-			fmt.Println(msg.data)
+			if coll.respectStreams && msg.stream == 2 {
+				fmt.Fprintln(os.Stderr, msg.data)
+			} else {
+				fmt.Println(msg.data)
+			}
 		case <-done:
-			close(coll.messages)
-			close(done)
 			return
 		}
 	}
 }
 
+// Displays each host's output grouped together, if the collector regroups
+// output
+func (coll *InterleavedIOCollector) Finish() {
+	for _, result := range coll.results {
+		printResult(result, coll.respectStreams)
+	}
+}
+
 // Reads output from a single RemoteIO and forwards it to the InterleavedIOCollector
 func (coll *InterleavedIOCollector) process(remote *RemoteIO) {
 	defer coll.waitgroup.Done()
@@ -234,38 +583,46 @@ type interleavedProcessor struct {
 	remote    *RemoteIO
 	curStream int
 	buf       bytes.Buffer
+
+	// Whether the last chunk ended with a carriage return
+	lastCR bool
+
+	// Everything received, if the collector regroups output
+	msgs []*IOMessage
+
+	// Puts the remote's messages back in order
+	seq messageSequencer
 }
 
 func (proc *interleavedProcessor) process() {
 	var result error
+
+	// Periodically flush partial lines, if requested
+	var tick <-chan time.Time
+	if proc.collector.flushInterval > 0 {
+		ticker := time.NewTicker(proc.collector.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 wait:
 	for {
 		select {
 		case msg := <-proc.remote.collector:
-			proc.handle(msg)
+			for _, msg := range proc.seq.add(msg) {
+				if proc.collector.regroup {
+					proc.msgs = append(proc.msgs, msg)
+				}
+				proc.handle(msg)
+			}
+		case <-tick:
+			proc.flushPartial()
 		case err := <-proc.remote.done:
 			result = err
 			break wait
 		}
 	}
 
-	// Give the data streams some time to finish sending.
-	t := time.NewTimer(deadline)
-wait2:
-	for {
-		select {
-		case msg := <-proc.remote.collector:
-			proc.handle(msg)
-
-			if !t.Stop() {
-				<-t.C
-			}
-			t.Reset(deadline)
-		case <-t.C:
-			break wait2
-		}
-	}
-
 	if result != nil {
 		proc.handle(&IOMessage{
 			data:   fmt.Sprintf("Failed with %s\n", result.Error()),
@@ -274,10 +631,16 @@ wait2:
 	}
 
 	proc.flush()
-	close(proc.remote.collector)
-	close(proc.remote.done)
+
+	if proc.collector.regroup {
+		proc.collector.lock.Lock()
+		proc.collector.results = append(proc.collector.results, proc.remote.result(proc.msgs, result))
+		proc.collector.lock.Unlock()
+	}
 }
 
+// Splits output into lines.  Carriage returns also end a line, so that
+// progress indicators that redraw a line are displayed as they update.
 func (proc *interleavedProcessor) handle(msg *IOMessage) {
 	if msg.stream != proc.curStream {
 		proc.flush()
@@ -285,8 +648,15 @@ func (proc *interleavedProcessor) handle(msg *IOMessage) {
 	}
 
 	data := msg.data
+
+	// Skip the \n of a \r\n that was split between chunks
+	if proc.lastCR && strings.HasPrefix(data, "\n") {
+		data = data[1:]
+	}
+
+	proc.lastCR = strings.HasSuffix(data, "\r")
 	for {
-		nl := strings.IndexByte(data, '\n')
+		nl := strings.IndexAny(data, "\r\n")
 		if nl < 0 {
 			break
 		}
@@ -295,23 +665,41 @@ func (proc *interleavedProcessor) handle(msg *IOMessage) {
 			proc.buf.WriteString(data[:nl])
 			proc.flush()
 		} else {
-			proc.send(data[:nl])
+			proc.send(data[:nl], false)
+		}
+
+		if strings.HasPrefix(data[nl:], "\r\n") {
+			nl++
 		}
 
 		data = data[nl+1:]
 	}
 
 	proc.buf.WriteString(data)
+	if proc.collector.unbuffered {
+		proc.flushPartial()
+	}
 }
 
+// Sends any complete line that is buffered
 func (proc *interleavedProcessor) flush() {
 	if proc.buf.Len() > 0 {
-		proc.send(proc.buf.String())
+		proc.send(proc.buf.String(), false)
+		proc.buf.Reset()
+	}
+}
+
+// Sends whatever is buffered, marked as an incomplete line
+func (proc *interleavedProcessor) flushPartial() {
+	if proc.buf.Len() > 0 {
+		proc.send(proc.buf.String(), true)
 		proc.buf.Reset()
 	}
 }
 
-func (proc *interleavedProcessor) send(line string) {
+// Sends a line to the collector, tagged with host and stream.  Partial lines
+// have a "+" after the stream, as the rest of the line follows separately.
+func (proc *interleavedProcessor) send(line string, partial bool) {
 	var stream string
 	switch proc.curStream {
 	case 1:
@@ -324,8 +712,12 @@ func (proc *interleavedProcessor) send(line string) {
 		stream = fmt.Sprintf("%03d", proc.curStream)
 	}
 
+	if partial {
+		stream += "+"
+	}
+
 	proc.collector.messages <- &IOMessage{
-		data:   fmt.Sprintf("%s [%s]: %s", proc.remote.host, stream, line),
+		data:   proc.collector.styles.apply(proc.curStream, fmt.Sprintf("%s [%s]: %s", proc.remote.host, stream, line)),
 		stream: proc.curStream,
 	}
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// One host's result, as written by JSONIOCollector and -format templates
+type HostRecord struct {
+	Host       string `json:"host"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Error      string `json:"error,omitempty"`
+}
+
+// IOCollector that writes results as JSON, either one array of every host
+// once they are all done, or one object per line as each host finishes.
+type JSONIOCollector struct {
+	RegularIOCollector
+	lines   bool
+	records []*HostRecord
+}
+
+// Makes a JSONIOCollector.  If lines is set, each host is written as soon as
+// it finishes.
+func NewJSONIOCollector(lines bool) IOCollector {
+	return &JSONIOCollector{
+		RegularIOCollector: RegularIOCollector{
+			results: make(chan *IOResult),
+		},
+		lines:   lines,
+		records: []*HostRecord{},
+	}
+}
+
+// Collects output from all RemoteIO's, writing each one as JSON straight
+// away with lines, or else keeping it for Finish, then returns
+func (coll *JSONIOCollector) Read() {
+	encoder := json.NewEncoder(os.Stdout)
+	for ; coll.read < coll.count; coll.read++ {
+		result := newHostRecord(<-coll.results)
+		if coll.lines {
+			encoder.Encode(result)
+		} else {
+			coll.records = append(coll.records, result)
+		}
+	}
+
+	coll.waitgroup.Wait()
+}
+
+// Writes every host's result as one JSON array, unless they were written as
+// they finished
+func (coll *JSONIOCollector) Finish() {
+	if coll.lines {
+		return
+	}
+
+	results := coll.records
+	sort.Slice(results, func(i, j int) bool { return results[i].Host < results[j].Host })
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(results)
+}
+
+// Converts a host's full output for encoding
+func newHostRecord(result *IOResult) *HostRecord {
+	converted := &HostRecord{
+		Host:       result.host,
+		ExitCode:   result.code,
+		DurationMs: int64(result.duration / time.Millisecond),
+		Stdout:     result.Stdout(),
+		Stderr:     result.Stderr(),
+	}
+
+	if result.result != nil {
+		converted.Error = result.result.Error()
+	}
+
+	return converted
+}
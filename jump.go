@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Function that opens a network connection, like net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// An SSH bastion that connections to the cluster are tunnelled through.  One
+// connection to the bastion is shared by every host, and is re-established if
+// it drops.
+type JumpHost struct {
+	address string
+	config  *ssh.ClientConfig
+	auth    *Auth
+	dial    DialFunc
+
+	lock   sync.Mutex
+	client *ssh.Client
+}
+
+// Creates a JumpHost for spec, given as [user@]host[:port], defaulting to
+// user and port.  The bastion's key is checked with verify, and it is
+// reached with dial (a plain net.Dialer if nil).
+func NewJumpHost(spec, user string, port int, auth *Auth, verify ssh.HostKeyCallback, dial DialFunc) (*JumpHost, error) {
+	target, err := ParseHostSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid jump host: %s", err.Error())
+	}
+
+	if target.User != "" {
+		user = target.User
+	}
+
+	if target.Port != 0 {
+		port = target.Port
+	}
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return &JumpHost{
+		address: net.JoinHostPort(target.Host, strconv.Itoa(port)),
+		auth:    auth,
+		dial:    dial,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth.getAuthMethods(),
+			HostKeyCallback: verify,
+		},
+	}, nil
+}
+
+// Opens a connection to address through the bastion, connecting to the
+// bastion first if necessary.  Implements DialFunc.
+func (jump *JumpHost) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := jump.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, address)
+	if err != nil && ctx.Err() == nil {
+		// The bastion connection may have dropped; try once more with a new one
+		jump.reset(client)
+		if client, err = jump.connect(ctx); err != nil {
+			return nil, err
+		}
+
+		conn, err = client.DialContext(ctx, network, address)
+	}
+
+	return conn, err
+}
+
+// Gets the connection to the bastion, making it if there isn't one
+func (jump *JumpHost) connect(ctx context.Context) (*ssh.Client, error) {
+	jump.lock.Lock()
+	defer jump.lock.Unlock()
+
+	if jump.client != nil {
+		return jump.client, nil
+	}
+
+	log.Printf("Connecting to jump host %s", jump.address)
+	conn, err := jump.dial(ctx, "tcp", jump.address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to jump host %s: %s", jump.address, err.Error())
+	}
+
+	config := *jump.config
+	var loggedIn func(error)
+	config.Auth, loggedIn = jump.auth.loginMethods()
+	c, chans, reqs, err := ssh.NewClientConn(conn, jump.address, &config)
+	loggedIn(err)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to connect to jump host %s: %s", jump.address, err.Error())
+	}
+
+	jump.client = ssh.NewClient(c, chans, reqs)
+	return jump.client, nil
+}
+
+// Forgets a connection to the bastion that has stopped working
+func (jump *JumpHost) reset(client *ssh.Client) {
+	jump.lock.Lock()
+	defer jump.lock.Unlock()
+
+	if jump.client == client {
+		client.Close()
+		jump.client = nil
+	}
+}
+
+// Closes the connection to the bastion
+func (jump *JumpHost) Close() {
+	jump.lock.Lock()
+	defer jump.lock.Unlock()
+
+	if jump.client != nil {
+		jump.client.Close()
+		jump.client = nil
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// Service name that passwords are filed under in the OS keyring
+const keyringService = "mesos-ssh"
+
+// Stores and retrieves the password for one cluster profile from the OS
+// keyring (Keychain, Secret Service or Windows Credential Manager).
+type Keyring struct {
+	account string
+}
+
+// Creates a Keyring entry for the specified user on the specified cluster.
+func NewKeyring(profile, user string) *Keyring {
+	return &Keyring{
+		account: fmt.Sprintf("%s@%s", user, profile),
+	}
+}
+
+// Looks up the stored password
+func (kr *Keyring) Get() (string, error) {
+	password, err := keyringGet(kr.account)
+	if err != nil {
+		return "", fmt.Errorf("No password in keyring for %s: %s", kr.account, err.Error())
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("No password in keyring for %s", kr.account)
+	}
+
+	return password, nil
+}
+
+// Removes the stored password
+func (kr *Keyring) Forget() error {
+	if err := keyringDelete(kr.account); err != nil {
+		return fmt.Errorf("Failed to remove password from keyring: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Saves the password, replacing any existing entry
+func (kr *Keyring) Set(password string) error {
+	if err := keyringSet(kr.account, password); err != nil {
+		return fmt.Errorf("Failed to store password in keyring: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Reads a password via the Keychain (macOS) or secret-tool (Secret Service)
+func keyringGet(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// Removes a password via the Keychain (macOS) or secret-tool (Secret Service)
+func keyringDelete(account string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)
+	} else {
+		cmd = exec.Command("secret-tool", "clear", "service", keyringService, "account", account)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// Writes a password via the Keychain (macOS) or secret-tool (Secret Service)
+func keyringSet(account, password string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// With -w last and no value, security prompts for the password (and
+		// again to confirm it) on stdin, keeping it off the command line
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w")
+		cmd.Stdin = strings.NewReader(password + "\n" + password + "\n")
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label", "mesos-ssh password for "+account, "service", keyringService, "account", account)
+		cmd.Stdin = strings.NewReader(password)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
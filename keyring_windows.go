@@ -0,0 +1,104 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32   = syscall.NewLazyDLL("advapi32.dll")
+	credReadW  = advapi32.NewProc("CredReadW")
+	credWriteW = advapi32.NewProc("CredWriteW")
+	credFree   = advapi32.NewProc("CredFree")
+	credDelete = advapi32.NewProc("CredDeleteW")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// Mirrors the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// Reads a generic credential from the Windows Credential Manager
+func keyringGet(account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ret, _, err := credReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", err
+	}
+
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// Writes a generic credential to the Windows Credential Manager
+func keyringSet(account, password string) error {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return err
+	}
+
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(password)
+	cred := &credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		UserName:           user,
+		Persist:            credPersistLocalMachine,
+		CredentialBlobSize: uint32(len(blob)),
+	}
+
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	ret, _, err := credWriteW.Call(uintptr(unsafe.Pointer(cred)), 0)
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a generic credential from the Windows Credential Manager
+func keyringDelete(account string) error {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return err
+	}
+
+	ret, _, err := credDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
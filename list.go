@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// A host as printed by list -json
+type listedHost struct {
+	Host  string       `json:"host"`
+	Agent *listedAgent `json:"agent,omitempty"`
+}
+
+// What Mesos knows about a host that is an agent
+type listedAgent struct {
+	Id         string             `json:"id"`
+	Active     bool               `json:"active"`
+	Public     bool               `json:"public"`
+	Attributes map[string]string  `json:"attributes,omitempty"`
+	Resources  map[string]float64 `json:"resources,omitempty"`
+}
+
+// Prints the hosts a spec resolves to, without connecting to any of them
+func listMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print a JSON array of hosts, with Mesos agent metadata")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] list <spec> [-json]", os.Args[0])
+	}
+
+	// List what a run would reach, which leaves out quarantined hosts
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err == nil {
+		hosts, err = skipQuarantined(hosts, msgs)
+	}
+
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	if !*asJSON {
+		for _, host := range hosts {
+			fmt.Println(host)
+		}
+
+		return
+	}
+
+	// Hosts that aren't agents (or when Mesos can't be reached) are listed
+	// without metadata
+	agents := make(map[string]*MesosAgent)
+	if resp, err := getAgents(flagMesos, msgs); err == nil {
+		for _, agent := range resp.Agents {
+			agents[agent.AgentInfo.Hostname] = agent
+		}
+	} else {
+		msgs.Printf("Failed to get agents from Mesos: %s", err.Error())
+	}
+
+	listed := []*listedHost{}
+	for _, host := range hosts {
+		entry := &listedHost{Host: host}
+		if agent, ok := agents[host]; ok {
+			entry.Agent = newListedAgent(agent)
+		}
+
+		listed = append(listed, entry)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(listed); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+}
+
+func newListedAgent(agent *MesosAgent) *listedAgent {
+	listed := &listedAgent{
+		Id:         agent.AgentInfo.Id.String(),
+		Active:     agent.Active,
+		Public:     hasPublicResource(agent),
+		Attributes: make(map[string]string),
+		Resources:  make(map[string]float64),
+	}
+
+	for _, attr := range agent.AgentInfo.Attributes {
+		listed.Attributes[attr.Name] = attr.String()
+	}
+
+	for _, resource := range agent.TotalResources {
+		if resource.Type == "SCALAR" {
+			listed.Resources[resource.Name] += resource.Scalar.Value
+		}
+	}
+
+	return listed
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// One setting in a manifest, as a single value or a list
+type manifestEntry struct {
+	key    string
+	values []string
+	list   bool
+	line   int
+}
+
+// A run described in a file: the host spec, the command or script and its
+// arguments, and any of the command line options, by name
+type manifest struct {
+	path    string
+	spec    string
+	command string
+	args    []string
+	flags   []*manifestEntry
+}
+
+// Runs the command described by a manifest file
+func applyMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	printOnly := fs.Bool("print", false, "Print the equivalent command line instead of running it")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] apply <manifest.yaml> [-print]", os.Args[0])
+	}
+
+	m, err := loadManifest(args[0])
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	if *printOnly {
+		fmt.Println(m.commandLine())
+		return
+	}
+
+	if err := m.setFlags(msgs); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	setup(msgs)
+	runMain(m.runArgs(), msgs)
+}
+
+// Reads and checks a manifest
+func loadManifest(path string) (*manifest, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseManifest(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	m := &manifest{path: path}
+	for _, entry := range entries {
+		switch entry.key {
+		case "spec", "command":
+			if entry.list {
+				return nil, fmt.Errorf("%s:%d: %s takes a single value", path, entry.line, entry.key)
+			}
+
+			if entry.key == "spec" {
+				m.spec = entry.values[0]
+			} else {
+				m.command = entry.values[0]
+			}
+		case "args":
+			m.args = entry.values
+		default:
+			f := flag.Lookup(entry.key)
+			if f == nil {
+				return nil, fmt.Errorf("%s:%d: Unknown option %s", path, entry.line, entry.key)
+			}
+
+			// The standard flag types are single values; the options
+			// that can be repeated have their own types
+			if _, single := f.Value.(flag.Getter); single && entry.list {
+				return nil, fmt.Errorf("%s:%d: %s takes a single value", path, entry.line, entry.key)
+			}
+
+			m.flags = append(m.flags, entry)
+		}
+	}
+
+	script := m.sets("script")
+	if m.spec == "" && !m.sets("from-results") {
+		return nil, fmt.Errorf("%s: No spec given", path)
+	} else if m.command == "" && !script {
+		return nil, fmt.Errorf("%s: No command or script given", path)
+	} else if m.command != "" && script {
+		return nil, fmt.Errorf("%s: command and script cannot be used together", path)
+	} else if len(m.args) > 0 && !script {
+		return nil, fmt.Errorf("%s: args are only for a script", path)
+	}
+
+	return m, nil
+}
+
+// Checks whether the manifest sets an option
+func (m *manifest) sets(name string) bool {
+	for _, entry := range m.flags {
+		if entry.key == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets the options the manifest gives, except for any that were given on the
+// command line, which take precedence
+func (m *manifest) setFlags(msgs *log.Logger) error {
+	for _, entry := range m.flags {
+		if flagWasSet(entry.key) {
+			msgs.Printf("Using -%s from the command line in place of %s's", entry.key, m.path)
+			continue
+		}
+
+		for _, value := range entry.values {
+			if err := flag.Set(entry.key, value); err != nil {
+				return fmt.Errorf("%s:%d: Invalid %s: %s", m.path, entry.line, entry.key, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Gets the arguments a run takes: the spec, unless hosts come from
+// -from-results, then the command or the script's arguments
+func (m *manifest) runArgs() []string {
+	var args []string
+	if m.spec != "" {
+		args = append(args, m.spec)
+	}
+
+	if m.command != "" {
+		return append(args, m.command)
+	}
+
+	return append(args, m.args...)
+}
+
+// Gets the command line that runs the same thing as the manifest
+func (m *manifest) commandLine() string {
+	words := []string{os.Args[0]}
+	for _, entry := range m.flags {
+		for _, value := range entry.values {
+			words = append(words, shellQuote("-"+entry.key+"="+value))
+		}
+	}
+
+	for _, arg := range m.runArgs() {
+		words = append(words, shellQuote(arg))
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Parses the subset of YAML that manifests use: a mapping of names to
+// scalars, block scalars ("|") or lists, either as "- item" lines or as
+// [a, b].  Comments start with "#".
+func parseManifest(contents string) ([]*manifestEntry, error) {
+	lines := strings.Split(strings.Replace(contents, "\r", "", -1), "\n")
+	var entries []*manifestEntry
+	seen := make(map[string]bool)
+	for i := 0; i < len(lines); i++ {
+		line := stripYAMLComment(lines[i])
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: Unexpected indentation; nested mappings aren't supported", i+1)
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: Expected key: value", i+1)
+		}
+
+		entry := &manifestEntry{key: strings.TrimSpace(line[:colon]), line: i + 1}
+		if seen[entry.key] {
+			return nil, fmt.Errorf("line %d: %s is given more than once", i+1, entry.key)
+		}
+
+		seen[entry.key] = true
+		value := strings.TrimSpace(line[colon+1:])
+		switch {
+		case value == "|" || value == "|-":
+			// Indented lines that follow, less their indentation
+			var block []string
+			indent := ""
+			for i+1 < len(lines) && (strings.TrimSpace(lines[i+1]) == "" || lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+				i++
+				text := lines[i]
+				if indent == "" && strings.TrimSpace(text) != "" {
+					indent = text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+				}
+
+				block = append(block, strings.TrimPrefix(text, indent))
+			}
+
+			text := strings.TrimRight(strings.Join(block, "\n"), "\n")
+			if value == "|" {
+				text += "\n"
+			}
+
+			entry.values = []string{text}
+		case value == "":
+			// A list of "- item" lines
+			entry.list = true
+			for i+1 < len(lines) {
+				item := strings.TrimSpace(stripYAMLComment(lines[i+1]))
+				if item == "" {
+					i++
+					continue
+				} else if !strings.HasPrefix(item, "- ") {
+					break
+				}
+
+				i++
+				scalar, err := parseYAMLScalar(strings.TrimSpace(item[2:]))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+				}
+
+				entry.values = append(entry.values, scalar)
+			}
+
+			if len(entry.values) == 0 {
+				return nil, fmt.Errorf("line %d: Expected a value or a list of \"- item\" lines for %s", entry.line, entry.key)
+			}
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("line %d: Lists in brackets must be on one line", i+1)
+			}
+
+			entry.list = true
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+
+				scalar, err := parseYAMLScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+				}
+
+				entry.values = append(entry.values, scalar)
+			}
+		default:
+			scalar, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+			}
+
+			entry.values = []string{scalar}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Removes a comment from the end of a line, outside of quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+
+	return strings.TrimRight(line, " \t")
+}
+
+// Parses a plain, single-quoted or double-quoted scalar
+func parseYAMLScalar(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("Bad quoted string %s", value)
+		}
+
+		return unquoted, nil
+	} else if strings.HasPrefix(value, "'") {
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("Bad quoted string %s", value)
+		}
+
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+
+	return value, nil
+}
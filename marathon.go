@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Pared-down Marathon client, for finding where apps run
+type MarathonClient struct {
+	endpoint string
+
+	// DC/OS ACS token, if Marathon is behind Admin Router
+	token string
+}
+
+type MarathonTasksResponse struct {
+	Tasks []*MarathonTask `json:"tasks"`
+}
+
+type MarathonTask struct {
+	Id    string `json:"id"`
+	AppId string `json:"appId"`
+	Host  string `json:"host"`
+	State string `json:"state"`
+}
+
+func NewMarathonClient(endpoint string) *MarathonClient {
+	return &MarathonClient{endpoint: strings.TrimRight(endpoint, "/")}
+}
+
+// Get the tasks of an app, by its ID (e.g. /prod/nginx)
+func (client *MarathonClient) GetAppTasks(id string) ([]*MarathonTask, error) {
+	path := "/" + strings.Trim(id, "/")
+	req, err := http.NewRequest("GET", client.endpoint+"/v2/apps"+(&url.URL{Path: path}).EscapedPath()+"/tasks", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.token != "" {
+		req.Header.Add("Authorization", "token="+client.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("No such Marathon app %s", path)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Marathon returned %s for app %s", resp.Status, path)
+	}
+
+	result := &MarathonTasksResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result.Tasks, nil
+}
+
+// Finds Marathon: behind Admin Router with -dcos-url, unless -marathon is
+// given, otherwise at -marathon
+func getMarathonClient() (*MarathonClient, error) {
+	if flagDCOSURL == "" || flagWasSet("marathon") {
+		return NewMarathonClient(flagMarathon), nil
+	}
+
+	token, err := dcosToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewMarathonClient(strings.TrimRight(flagDCOSURL, "/") + "/service/marathon")
+	client.token = token
+	return client, nil
+}
+
+// Lookup the agents running an app's instances
+func getAppHosts(id string) ([]string, error) {
+	client, err := getMarathonClient()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := client.GetAppTasks(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		// Tasks that are staging or being killed may not be on the host for long
+		if task.State != "" && task.State != "TASK_RUNNING" {
+			continue
+		}
+
+		if task.Host != "" && !seen[task.Host] {
+			seen[task.Host] = true
+			result = append(result, task.Host)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("Marathon app %s has no running tasks", id)
+	}
+
+	return result, nil
+}
@@ -9,22 +9,42 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Lookup hosts for "spec" from mesos leader "mesos". Write any output to msgs.
+// Lookup hosts for "spec" from mesos leader "mesos", keeping those that
+// -match and aren't excluded.  Write any output to msgs.
 func GetHosts(mesos, spec string, msgs *log.Logger) ([]string, error) {
+	hosts, err := getHosts(mesos, spec, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterHosts(hosts)
+}
+
+// Lookup hosts for "spec" from mesos leader "mesos"
+func getHosts(mesos, spec string, msgs *log.Logger) ([]string, error) {
 	if spec == "masters" {
 		return getMasters()
 	}
 
+	if strings.HasPrefix(spec, "exec:") {
+		return getExecHosts(strings.TrimPrefix(spec, "exec:"))
+	}
+
+	if spec == "-" {
+		return getStdinHosts()
+	}
+
+	if strings.HasPrefix(spec, "app:") {
+		return getAppHosts(strings.TrimPrefix(spec, "app:"))
+	}
+
 	if spec == "agents" || spec == "all" || spec == "public" || spec == "private" {
 		var result []string
-		mesosClient, err := discoverMesos(mesos, msgs)
-		if err != nil {
-			return result, err
-		}
-
-		agents, err := mesosClient.GetAgents()
+		agents, err := getAgents(mesos, msgs)
 		if err != nil {
 			return result, err
 		}
@@ -72,15 +92,72 @@ func GetHosts(mesos, spec string, msgs *log.Logger) ([]string, error) {
 	}
 }
 
-// Pared-down mesos client.
+// Pared-down mesos client.  Safe for concurrent use; requests are paced to
+// at most rate per second, and responses are cached for the life of the
+// client so that repeated lookups don't reach the master at all.
 type MesosClient struct {
 	endpoint string
+	http     *http.Client
+
+	// DC/OS ACS token, if the master is behind Admin Router, or else the
+	// principal and secret for HTTP basic auth
+	token    string
+	user     string
+	password string
+
+	// Minimum time between requests, and when the last one was sent
+	interval time.Duration
+	pace     sync.Mutex
+	last     time.Time
+
+	lock  sync.Mutex
+	cache map[string]*mesosCacheEntry
+}
+
+// A response in the cache, or one that is still being requested
+type mesosCacheEntry struct {
+	ready    chan struct{}
+	response *MesosResponse
+	err      error
 }
 
-func NewMesosClient(endpoint string) *MesosClient {
-	return &MesosClient{
+// Creates a MesosClient that makes at most rate requests per second (0 for
+// no limit) with httpClient
+func NewMesosClient(endpoint string, rate float64, httpClient *http.Client) *MesosClient {
+	client := &MesosClient{
 		endpoint: endpoint,
+		http:     httpClient,
+		cache:    make(map[string]*mesosCacheEntry),
 	}
+
+	if rate > 0 {
+		client.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	return client
+}
+
+// The client shared by everything in this run, once found
+var (
+	sharedMesos     *MesosClient
+	sharedMesosLock sync.Mutex
+)
+
+// Finds the Mesos leader, reusing the client from earlier lookups
+func getMesosClient(mesos string, msgs *log.Logger) (*MesosClient, error) {
+	sharedMesosLock.Lock()
+	defer sharedMesosLock.Unlock()
+
+	if sharedMesos == nil {
+		client, err := discoverMesos(mesos, msgs)
+		if err != nil {
+			return nil, err
+		}
+
+		sharedMesos = client
+	}
+
+	return sharedMesos, nil
 }
 
 // Get all agents
@@ -92,6 +169,68 @@ func (client *MesosClient) GetAgents() (*MesosAgentsResponse, error) {
 	}
 }
 
+// Get roles, with their weights and frameworks
+func (client *MesosClient) GetRoles() (*MesosRolesResponse, error) {
+	if response, err := client.makeRequest(&MesosRequest{Type: "GET_ROLES"}); err != nil {
+		return nil, err
+	} else {
+		return response.RolesResponse, nil
+	}
+}
+
+// Get quotas
+func (client *MesosClient) GetQuota() (*MesosQuotaResponse, error) {
+	if response, err := client.makeRequest(&MesosRequest{Type: "GET_QUOTA"}); err != nil {
+		return nil, err
+	} else {
+		return response.QuotaResponse, nil
+	}
+}
+
+// Get running tasks
+func (client *MesosClient) GetTasks() (*MesosTasksResponse, error) {
+	if response, err := client.makeRequest(&MesosRequest{Type: "GET_TASKS"}); err != nil {
+		return nil, err
+	} else {
+		return response.TasksResponse, nil
+	}
+}
+
+// Get frameworks
+func (client *MesosClient) GetFrameworks() (*MesosFrameworksResponse, error) {
+	if response, err := client.makeRequest(&MesosRequest{Type: "GET_FRAMEWORKS"}); err != nil {
+		return nil, err
+	} else {
+		return response.FrameworksResponse, nil
+	}
+}
+
+// Tear down a framework, killing all of its tasks.  Never cached.
+func (client *MesosClient) Teardown(frameworkId string) error {
+	var buf bytes.Buffer
+	request := &MesosRequest{
+		Type:     "TEARDOWN",
+		Teardown: &MesosTeardown{FrameworkId: MesosTextValue{Value: &frameworkId}},
+	}
+
+	if err := json.NewEncoder(&buf).Encode(request); err != nil {
+		return err
+	}
+
+	resp, err := client.post(&buf)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Mesos failed to tear down %s: %s %s", frameworkId, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
 // Get version. Used to check for a Mesos endpoint.
 func (client *MesosClient) GetVersion() (*MesosVersionResponse, error) {
 	if response, err := client.makeRequest(&MesosRequest{Type: "GET_VERSION"}); err != nil {
@@ -103,26 +242,75 @@ func (client *MesosClient) GetVersion() (*MesosVersionResponse, error) {
 
 // Lookup mesos masters
 func getMasters() ([]string, error) {
-	return net.LookupHost("master.mesos")
+	if flagNoDNS {
+		return nil, fmt.Errorf("Masters can't be found with -no-dns-discovery; list them in a host file instead")
+	}
+
+	return net.LookupHost(flagMastersDNS)
 }
 
-// Make a request to Mesos
+// Make a request to Mesos, or take the response from the cache.  Concurrent
+// identical requests share one round trip.
 func (client *MesosClient) makeRequest(request *MesosRequest) (*MesosResponse, error) {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(request); err != nil {
 		return nil, err
 	}
 
-	httpClient := &http.Client{}
+	key := buf.String()
+	client.lock.Lock()
+	if entry, ok := client.cache[key]; ok {
+		client.lock.Unlock()
+		<-entry.ready
+		return entry.response, entry.err
+	}
 
-	req, err := http.NewRequest("POST", client.endpoint+"/api/v1", &buf)
-	if err != nil {
-		return nil, err
+	entry := &mesosCacheEntry{ready: make(chan struct{})}
+	client.cache[key] = entry
+	client.lock.Unlock()
+
+	entry.response, entry.err = client.send(request, &buf)
+	if entry.err != nil {
+		// Don't cache failures
+		client.lock.Lock()
+		delete(client.cache, key)
+		client.lock.Unlock()
 	}
 
-	req.Header.Add("Content-type", "application/json")
-	resp, err := httpClient.Do(req)
+	close(entry.ready)
+	return entry.response, entry.err
+}
+
+// Forgets cached responses, so that the next requests see the current state
+func (client *MesosClient) Refresh() {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for key, entry := range client.cache {
+		select {
+		case <-entry.ready:
+			delete(client.cache, key)
+		default:
+			// Still in flight; leave it for its waiters
+		}
+	}
+}
+
+// Waits until another request is allowed
+func (client *MesosClient) wait() {
+	client.pace.Lock()
+	defer client.pace.Unlock()
 
+	if delay := time.Until(client.last.Add(client.interval)); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	client.last = time.Now()
+}
+
+// Sends an encoded request to Mesos
+func (client *MesosClient) send(request *MesosRequest, body *bytes.Buffer) (*MesosResponse, error) {
+	resp, err := client.post(body)
 	if err != nil {
 		return nil, err
 	}
@@ -140,39 +328,126 @@ func (client *MesosClient) makeRequest(request *MesosRequest) (*MesosResponse, e
 	return result, nil
 }
 
+// Posts an encoded request to the operator API, and checks that Mesos didn't
+// refuse it
+func (client *MesosClient) post(body *bytes.Buffer) (*http.Response, error) {
+	client.wait()
+	req, err := http.NewRequest("POST", client.endpoint+"/api/v1", body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-type", "application/json")
+	if client.token != "" {
+		req.Header.Add("Authorization", "token="+client.token)
+	} else if client.user != "" {
+		req.SetBasicAuth(client.user, client.password)
+	}
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Mesos refused the request: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
 // Find Mesos leader
 func discoverMesos(mesosUri string, msgs *log.Logger) (*MesosClient, error) {
+	httpClient, err := newMesosHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if flagDCOSURL != "" {
+		return dcosMesosClient(flagDCOSURL, httpClient)
+	}
+
+	user, password, err := mesosCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	newClient := func(uri string) *MesosClient {
+		client := NewMesosClient(uri, flagMesosRate, httpClient)
+		client.user, client.password = user, password
+		return client
+	}
+
+	scheme := "http"
+	if flagMesosHTTPS {
+		scheme = "https"
+	}
+
 	if mesosUri != "" {
-		client := NewMesosClient(mesosUri)
+		client := newClient(mesosUri)
 		_, err := client.GetVersion()
 		if err == nil {
 			// This works- take the client-supplied endpoint
 			return client, nil
 		}
 
+		if flagNoDNS {
+			return nil, fmt.Errorf("Failed checking %s: %s", mesosUri, err.Error())
+		}
+
 		msgs.Println("Failed to connect to Mesos with client-supplied path, trying autodiscovery.")
 	}
 
-	if _, addrs, err := net.LookupSRV("leader", "tcp", "mesos"); err == nil && len(addrs) > 0 {
+	if flagNoDNS {
+		return nil, fmt.Errorf("No Mesos address given, and -no-dns-discovery is set")
+	}
+
+	// With empty service and proto, the whole record name is looked up
+	if _, addrs, err := net.LookupSRV("", "", flagLeaderSRV); err == nil && len(addrs) > 0 {
 		for _, addr := range addrs {
-			uri := fmt.Sprintf("http://%s:%s", addr.Target, addr.Port)
-			client := NewMesosClient(uri)
+			uri := fmt.Sprintf("%s://%s:%d", scheme, addr.Target, addr.Port)
+			client := newClient(uri)
 			_, err := client.GetVersion()
 			if err == nil {
 				return client, nil
 			}
 		}
 	} else {
-		msgs.Printf("Failed to lookup leader.mesos SRV record: %s", err.Error())
+		msgs.Printf("Failed to lookup %s SRV record: %s", flagLeaderSRV, err.Error())
 	}
 
-	// Try http://leader.mesos:5050
-	client := NewMesosClient("http://leader.mesos:5050")
+	// Try the leader on Mesos's default port
+	leader := net.JoinHostPort(flagLeaderDNS, "5050")
+	client := newClient(scheme + "://" + leader)
 	if _, err := client.GetVersion(); err == nil {
 		return client, nil
 	} else {
-		return nil, fmt.Errorf("Failed checking leader.mesos:5050: %s", err.Error())
+		return nil, fmt.Errorf("Failed checking %s: %s", leader, err.Error())
+	}
+}
+
+// Gets the principal and secret for the Mesos API from -mesos-user and
+// -mesos-passfile, if set
+func mesosCredentials() (string, string, error) {
+	if flagMesosUser == "" {
+		if flagMesosPass != "" {
+			return "", "", fmt.Errorf("-mesos-passfile needs -mesos-user")
+		}
+
+		return "", "", nil
+	}
+
+	if flagMesosPass == "" {
+		return flagMesosUser, "", nil
 	}
+
+	contents, err := ioutil.ReadFile(flagMesosPass)
+	if err != nil {
+		return "", "", err
+	}
+
+	return flagMesosUser, strings.TrimRight(string(contents), "\r\n"), nil
 }
 
 // Find hosts of agents that match a predicate
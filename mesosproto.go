@@ -1,18 +1,32 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Serialization format for mesos HTTP API protocol
 
 type MesosRequest struct {
 	Type           string          `json:"type"`
 	MetricsTimeout *MesosTimestamp `json:"get_metrics,omitempty"`
+	Teardown       *MesosTeardown  `json:"teardown,omitempty"`
+}
+
+type MesosTeardown struct {
+	FrameworkId MesosTextValue `json:"framework_id"`
 }
 
 type MesosResponse struct {
-	Type            string                `json:"type"`
-	AgentsResponse  *MesosAgentsResponse  `json:"get_agents"`
-	VersionResponse *MesosVersionResponse `json:"get_version"`
+	Type               string                   `json:"type"`
+	AgentsResponse     *MesosAgentsResponse     `json:"get_agents"`
+	VersionResponse    *MesosVersionResponse    `json:"get_version"`
+	RolesResponse      *MesosRolesResponse      `json:"get_roles"`
+	QuotaResponse      *MesosQuotaResponse      `json:"get_quota"`
+	TasksResponse      *MesosTasksResponse      `json:"get_tasks"`
+	FrameworksResponse *MesosFrameworksResponse `json:"get_frameworks"`
 }
 
 type MesosVersionResponse struct {
@@ -24,6 +38,70 @@ type MesosVersionResponse struct {
 	} `json:"version_info"`
 }
 
+type MesosRolesResponse struct {
+	Roles []*MesosRole `json:"roles"`
+}
+
+type MesosRole struct {
+	Name       string           `json:"name"`
+	Weight     float64          `json:"weight"`
+	Frameworks []MesosTextValue `json:"frameworks"`
+	Resources  []*MesosResource `json:"resources"`
+}
+
+type MesosQuotaResponse struct {
+	Status struct {
+		Infos   []*MesosQuotaInfo   `json:"infos"`
+		Configs []*MesosQuotaConfig `json:"configs"`
+	} `json:"status"`
+}
+
+// Quota as set by Mesos before 1.9
+type MesosQuotaInfo struct {
+	Role      string           `json:"role"`
+	Guarantee []*MesosResource `json:"guarantee"`
+}
+
+// Quota as set by Mesos 1.9 and later
+type MesosQuotaConfig struct {
+	Role       string                 `json:"role"`
+	Guarantees map[string]MesosScalar `json:"guarantees"`
+	Limits     map[string]MesosScalar `json:"limits"`
+}
+
+type MesosScalar struct {
+	Value float64 `json:"value"`
+}
+
+type MesosTasksResponse struct {
+	Tasks []*MesosTask `json:"tasks"`
+}
+
+type MesosTask struct {
+	Name        string         `json:"name"`
+	TaskId      MesosTextValue `json:"task_id"`
+	FrameworkId MesosTextValue `json:"framework_id"`
+	AgentId     MesosTextValue `json:"agent_id"`
+	State       string         `json:"state"`
+}
+
+type MesosFrameworksResponse struct {
+	Frameworks []*MesosFramework `json:"frameworks"`
+}
+
+type MesosFramework struct {
+	FrameworkInfo struct {
+		Id         MesosTextValue `json:"id"`
+		Name       string         `json:"name"`
+		Checkpoint bool           `json:"checkpoint"`
+		User       string         `json:"user"`
+		Role       string         `json:"role"`
+		Roles      []string       `json:"roles"`
+	} `json:"framework_info"`
+	Active    bool `json:"active"`
+	Connected bool `json:"connected"`
+}
+
 type MesosAgentsResponse struct {
 	Agents []*MesosAgent `json:"agents"`
 }
@@ -34,14 +112,32 @@ type MesosAgent struct {
 	AllocatedResources []*MesosResource `json:"allocated_resources"`
 	Pid                string           `json:"pid"`
 	RegisteredTime     MesosTimestamp   `json:"registered_time"`
+	ReregisteredTime   MesosTimestamp   `json:"reregistered_time"`
 	TotalResources     []*MesosResource `json:"total_resources"`
 }
 
 type MesosAgentInfo struct {
-	Hostname  string           `json:"hostname"`
-	Id        MesosTextValue   `json:"id"`
-	Port      int              `json:"port"`
-	Resources []*MesosResource `json:"resources"`
+	Hostname   string            `json:"hostname"`
+	Id         MesosTextValue    `json:"id"`
+	Port       int               `json:"port"`
+	Resources  []*MesosResource  `json:"resources"`
+	Attributes []*MesosAttribute `json:"attributes"`
+}
+
+type MesosAttribute struct {
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Text   MesosTextValue `json:"text"`
+	Scalar MesosScalar    `json:"scalar"`
+	Ranges struct {
+		Range []struct {
+			Begin int `json:"begin"`
+			End   int `json:"end"`
+		} `json:"range"`
+	} `json:"ranges"`
+	Set struct {
+		Item []string `json:"item"`
+	} `json:"set"`
 }
 
 type MesosTextValue struct {
@@ -79,6 +175,23 @@ func (text *MesosTextValue) String() string {
 	return ""
 }
 
+// Formats the attribute's value the way Mesos writes it on the command line
+func (attr *MesosAttribute) String() string {
+	switch attr.Type {
+	case "SCALAR":
+		return strconv.FormatFloat(attr.Scalar.Value, 'f', -1, 64)
+	case "RANGES":
+		var ranges []string
+		for _, r := range attr.Ranges.Range {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.Begin, r.End))
+		}
+		return "[" + strings.Join(ranges, ",") + "]"
+	case "SET":
+		return "{" + strings.Join(attr.Set.Item, ",") + "}"
+	}
+	return attr.Text.String()
+}
+
 func (timestamp *MesosTimestamp) Time() time.Time {
 	return time.Unix(0, timestamp.Nanoseconds)
 }
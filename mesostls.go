@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Builds the HTTP client for Mesos API requests, set up for TLS by
+// -mesos-ca, -mesos-cert, -mesos-key and -insecure-skip-verify
+func newMesosHTTPClient() (*http.Client, error) {
+	if flagMesosCA == "" && flagMesosCert == "" && flagMesosKey == "" && !flagInsecureTLS {
+		return &http.Client{}, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: flagInsecureTLS}
+	if flagMesosCA != "" {
+		contents, err := ioutil.ReadFile(flagMesosCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("No certificates found in %s", flagMesosCA)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if (flagMesosCert == "") != (flagMesosKey == "") {
+		return nil, fmt.Errorf("-mesos-cert and -mesos-key must be used together")
+	} else if flagMesosCert != "" {
+		cert, err := tls.LoadX509KeyPair(flagMesosCert, flagMesosKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %s", err.Error())
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// Lines that sudo may print before its password prompt, which say nothing
+// about the command itself
+var defaultNoise = []string{
+	`^We trust you have received the usual lecture`,
+	`^Administrator\. It usually boils down to these`,
+	`^\s*#[123]\) (Respect the privacy|Think before you type|With great power)`,
+	`^sudo: unable to resolve host `,
+}
+
+// Filter applied to sudo's output before the password prompt, set up from
+// the command line
+var sudoNoise *NoiseFilter
+
+// Recognizes noise lines, such as the sudo lecture, in output
+type NoiseFilter struct {
+	patterns []*regexp.Regexp
+}
+
+// Creates a NoiseFilter for lines matching any of the regular expressions
+func NewNoiseFilter(patterns []string) (*NoiseFilter, error) {
+	filter := &NoiseFilter{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid noise pattern %s: %s", pattern, err.Error())
+		}
+
+		filter.patterns = append(filter.patterns, re)
+	}
+
+	return filter, nil
+}
+
+// Checks whether a line of output is noise
+func (filter *NoiseFilter) Matches(line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	for _, re := range filter.patterns {
+		if re.Match(line) {
+			return true
+		}
+	}
+
+	return false
+}
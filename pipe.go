@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Holds back a host's stdout, to be run through a local command once the
+// host is done
+type stdoutPipe struct {
+	command string
+	buf     bytes.Buffer
+}
+
+// Runs the held back stdout through the command, with $MESOS_SSH_HOST set to
+// host, and returns what the command wrote to stdout and stderr
+func (pipe *stdoutPipe) run(host string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := shellCommand(pipe.command)
+	cmd.Env = append(os.Environ(), "MESOS_SSH_HOST="+host)
+	cmd.Stdin = &pipe.buf
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// IOCollector that runs each host's stdout through a local command, such as
+// a jq filter, and otherwise leaves the output to another collector
+type PipeIOCollector struct {
+	IOCollector
+	command string
+}
+
+// Wraps coll so that each host's stdout is replaced by the output of command
+func NewPipeIOCollector(coll IOCollector, command string) IOCollector {
+	return &PipeIOCollector{
+		IOCollector: coll,
+		command:     command,
+	}
+}
+
+// Creates a new RemoteIO for the specified host
+func (coll *PipeIOCollector) NewRemote(host string) *RemoteIO {
+	remote := coll.IOCollector.NewRemote(host)
+	remote.pipe = &stdoutPipe{command: coll.command}
+	return remote
+}
+
+// Sends the host's stdout through the pipe command, in place of the output
+// itself.  Hosts with no stdout at all, such as those that couldn't be
+// reached, are left out.
+func (remote *RemoteIO) flushPipe() {
+	pipe := remote.pipe
+	remote.pipe = nil
+	if pipe.buf.Len() == 0 {
+		return
+	}
+
+	stdout, stderr, err := pipe.run(remote.host)
+	if len(stdout) > 0 {
+		remote.sendStdout(stdout)
+	}
+
+	if len(stderr) > 0 {
+		remote.Stderr(stderr)
+	}
+
+	if err != nil {
+		remote.Status(fmt.Sprintf("-pipe command failed: %s\n", err.Error()))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Prints the installed version of a package, using whichever of dpkg or rpm
+// the host has.  %[1]s is the quoted package name.
+const pkgScript = `if command -v dpkg-query >/dev/null 2>&1; then
+	dpkg-query -W -f='${Status}\t${Version}\n' %[1]s 2>/dev/null | awk -F'\t' '$1 ~ / installed$/ { print $2; found = 1 } END { if (!found) print "(not installed)" }'
+elif command -v rpm >/dev/null 2>&1; then
+	rpm -q %[1]s >/dev/null 2>&1 && rpm -q --qf '%%{VERSION}-%%{RELEASE}\n' %[1]s || echo '(not installed)'
+else
+	echo '(no package manager)'
+fi`
+
+// Reports which versions of a package are installed across the hosts
+func pkgMain(args []string, msgs *log.Logger) {
+	if len(args) != 2 {
+		msgs.Fatalf("Usage: %s [OPTIONS] pkg <spec> <package>", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand(fmt.Sprintf(pkgScript, shellQuote(args[1])), false, false, false, flagTimeout, nil)
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	// Group hosts by version
+	versions := make(map[string][]string)
+	for _, result := range coll.Results {
+		var version string
+		if result.result != nil {
+			version = "(failed: " + result.result.Error() + ")"
+		} else {
+			lines := strings.Fields(result.Stdout())
+			version = strings.Join(lines, ", ")
+			if version == "" {
+				version = "(unknown)"
+			}
+		}
+
+		versions[version] = append(versions[version], result.host)
+	}
+
+	printHistogram(os.Stdout, "VERSION", versions)
+	runner.Finish()
+}
+
+// Prints groups of hosts as a table, largest group first
+func printHistogram(out io.Writer, title string, groups map[string][]string) {
+	var keys []string
+	for key, hosts := range groups {
+		keys = append(keys, key)
+		sort.Strings(hosts)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCOUNT\tHOSTS\n", title)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", key, len(groups[key]), strings.Join(groups[key], ", "))
+	}
+
+	w.Flush()
+}
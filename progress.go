@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many of a set of hosts are at each stage of a run
+type progressCounts struct {
+	queued  int
+	running int
+	done    int
+	failed  int
+}
+
+func (counts *progressCounts) String() string {
+	s := fmt.Sprintf("%d running, %d queued, %d done", counts.running, counts.queued, counts.done)
+	if counts.failed > 0 {
+		s += fmt.Sprintf(" (%d failed)", counts.failed)
+	}
+
+	return s
+}
+
+// Tracks the hosts of a run through queued, running and done, overall and
+// for each group they belong to, for -progress to report
+type runProgress struct {
+	lock   sync.Mutex
+	total  progressCounts
+	groups map[string]string
+	counts map[string]*progressCounts
+	names  []string
+}
+
+// Starts tracking hosts, all of them queued.  groups gives each host's group
+// (e.g. its zone); with no groups only the totals are reported.
+func newRunProgress(hosts []string, groups map[string]string) *runProgress {
+	progress := &runProgress{
+		total:  progressCounts{queued: len(hosts)},
+		groups: groups,
+		counts: make(map[string]*progressCounts),
+	}
+
+	if groups != nil {
+		for _, host := range hosts {
+			group := groups[host]
+			counts, ok := progress.counts[group]
+			if !ok {
+				counts = &progressCounts{}
+				progress.counts[group] = counts
+				progress.names = append(progress.names, group)
+			}
+
+			counts.queued++
+		}
+
+		// Hosts without a group, such as masters, come last
+		names := progress.names
+		sort.Slice(names, func(i, j int) bool { return names[j] == "" || (names[i] != "" && names[i] < names[j]) })
+	}
+
+	return progress
+}
+
+// Records that a host has started running
+func (progress *runProgress) Start(host string) {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	for _, counts := range progress.countsFor(host) {
+		counts.queued--
+		counts.running++
+	}
+}
+
+// Records that a host has finished, and whether it failed
+func (progress *runProgress) Finish(host string, failed bool) {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	for _, counts := range progress.countsFor(host) {
+		counts.running--
+		counts.done++
+		if failed {
+			counts.failed++
+		}
+	}
+}
+
+// Gets the counts a host's progress is recorded in
+func (progress *runProgress) countsFor(host string) []*progressCounts {
+	if progress.groups == nil {
+		return []*progressCounts{&progress.total}
+	}
+
+	return []*progressCounts{&progress.total, progress.counts[progress.groups[host]]}
+}
+
+// Describes where the run is, with a line for each group.  Groups that are
+// done are marked so, to make those that are stuck stand out.
+func (progress *runProgress) String() string {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	lines := []string{"Progress: " + progress.total.String()}
+	for _, name := range progress.names {
+		counts := progress.counts[name]
+		label := name
+		if label == "" {
+			label = "(none)"
+		}
+
+		line := fmt.Sprintf("  %s: %s", label, counts.String())
+		if counts.queued == 0 && counts.running == 0 {
+			line += ", finished"
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Logs the progress every interval until stop is closed
+func (progress *runProgress) Report(interval time.Duration, msgs *log.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			msgs.Print(progress.String())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Looks up the groups -progress-by breaks the progress down by, defaulting to
+// the domains of -batch-by.  Returns nil for no breakdown.
+func progressGroups(msgs *log.Logger) (map[string]string, error) {
+	by := flagProgressBy
+	if by == "" {
+		by = flagBatchBy
+	}
+
+	if by == "" {
+		return nil, nil
+	} else if !strings.HasPrefix(by, "attribute:") || by == "attribute:" {
+		return nil, fmt.Errorf("Invalid -progress-by %s, expected attribute:NAME", by)
+	}
+
+	attribute := strings.TrimPrefix(by, "attribute:")
+	domains, err := agentDomains(attribute, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Label each group with the attribute, e.g. "zone=us-east-1a"
+	groups := make(map[string]string)
+	for host, value := range domains {
+		groups[host] = attribute + "=" + value
+	}
+
+	return groups, nil
+}
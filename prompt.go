@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Shared reader for answers typed on stdin
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Reads stdin for every prompt, one line at a time, so that a prompt that
+// gives up hands its read on to the next prompt rather than leaving it
+// behind to steal the next answer
+var stdinLines = &lineReader{lines: make(chan *stdinLine, 1)}
+
+// Returned by lineReader.read when it gives up
+var errPromptTimeout = errors.New("Timed out")
+
+// A line read from stdin
+type stdinLine struct {
+	line string
+	err  error
+}
+
+type lineReader struct {
+	lock    sync.Mutex
+	pending bool
+	lines   chan *stdinLine
+}
+
+// Reads a line, without echo if secret is set, giving up when timeout fires.
+// A read that was given up on answers the next call instead.
+func (reader *lineReader) read(secret bool, timeout <-chan time.Time) (string, error) {
+	reader.lock.Lock()
+	if !reader.pending {
+		reader.pending = true
+		go func() {
+			line := &stdinLine{}
+			if secret {
+				password, err := terminal.ReadPassword(0)
+				line.line, line.err = string(password), err
+			} else {
+				line.line, line.err = stdinReader.ReadString('\n')
+			}
+
+			reader.lines <- line
+		}()
+	}
+
+	reader.lock.Unlock()
+
+	select {
+	case line := <-reader.lines:
+		reader.lock.Lock()
+		reader.pending = false
+		reader.lock.Unlock()
+		return line.line, line.err
+	case <-timeout:
+		return "", errPromptTimeout
+	}
+}
+
+// Checks whether there is an operator at the terminal to answer questions
+func interactive() bool {
+	return terminal.IsTerminal(0)
+}
+
+// Asks a question on the terminal and reads back one line
+func ask(question string) string {
+	fmt.Fprintf(os.Stderr, "%s ", question)
+	answer, _ := stdinLines.read(false, nil)
+	return strings.TrimSpace(answer)
+}
+
+// Asks a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	answer := strings.ToLower(ask(question + " [y/N]"))
+	return answer == "y" || answer == "yes"
+}
+
+// Commands that usually do damage if run on the wrong hosts
+var destructivePattern = regexp.MustCompile(`(^|[;&|(\s])(sudo\s+)?(rm|rmdir|dd|mkfs(\.\w+)?|wipefs|shred|reboot|shutdown|halt|poweroff|kill|pkill|killall|userdel|iptables|docker\s+(rm|rmi|kill|stop|system\s+prune)|systemctl\s+(stop|restart|disable|mask|reboot|poweroff))\b`)
+
+// Checks whether a command looks destructive enough to confirm first
+func destructive(command string) bool {
+	return destructivePattern.MatchString(command)
+}
+
+// Makes the operator type the number of hosts, or "yes-N-hosts", before
+// running on them
+func confirmHostCount(command string, count int) bool {
+	fmt.Fprintf(os.Stderr, "About to run %q on %d hosts.\n", command, count)
+	answer := ask(fmt.Sprintf("Type %d (or yes-%d-hosts) to continue:", count, count))
+	return answer == strconv.Itoa(count) || answer == fmt.Sprintf("yes-%d-hosts", count)
+}
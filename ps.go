@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Columns ps prints for each process, and how the listing is recognized so
+// that it can be left out of its own results
+const psColumns = "pid=,ppid=,user=,pcpu=,pmem=,args="
+
+// Prints the shell's PID, then every process
+const psCommand = "echo $$; ps -eo " + psColumns
+
+// Signals that -kill takes, by name or number
+var psSignalPattern = regexp.MustCompile(`^(SIG)?([A-Z][A-Z0-9+-]*|[0-9]+)$`)
+
+// A process found on a host
+type psProcess struct {
+	host string
+	pid  int
+	user string
+	cpu  float64
+	mem  float64
+	args string
+}
+
+// Finds processes whose command line matches a pattern on every host, and
+// optionally signals them
+func psMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("ps", flag.ExitOnError)
+	sortBy := fs.String("sort", "cpu", "Sort by cpu, mem, host, pid or user")
+	signal := fs.String("kill", "", "After confirming, send this signal (e.g. SIGTERM or 9) to the processes found")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 2 {
+		msgs.Fatalf("Usage: %s [OPTIONS] ps <spec> <pattern> [-sort cpu|mem|host|pid|user] [-kill signal]", os.Args[0])
+	}
+
+	pattern, err := regexp.Compile(args[1])
+	if err != nil {
+		msgs.Fatalf("Invalid pattern %s: %s", args[1], err.Error())
+	}
+
+	less, err := psSorter(*sortBy)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	signalName := strings.ToUpper(*signal)
+	if *signal != "" {
+		if !psSignalPattern.MatchString(signalName) {
+			msgs.Fatalf("Invalid -kill signal %s", *signal)
+		} else if !interactive() {
+			msgs.Fatalf("-kill needs a terminal to confirm at")
+		}
+
+		signalName = strings.TrimPrefix(signalName, "SIG")
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	runner.Run(context.Background(), hosts, NewSSHCommand(psCommand, flagSudo, flagPty, false, flagTimeout, nil), coll)
+
+	var procs []*psProcess
+	failed := 0
+	for _, result := range coll.Results {
+		if result.result != nil {
+			msgs.Printf("Failed on %s: %s", result.host, result.result.Error())
+			failed++
+			continue
+		}
+
+		procs = append(procs, parsePs(result.host, result.Stdout(), pattern)...)
+	}
+
+	sort.SliceStable(procs, func(i, j int) bool { return less(procs[i], procs[j]) })
+	printPs(os.Stdout, procs)
+
+	byHost := make(map[string][]int)
+	for _, proc := range procs {
+		byHost[proc.host] = append(byHost[proc.host], proc.pid)
+	}
+
+	fmt.Printf("\n%d processes on %d of %d hosts", len(procs), len(byHost), len(hosts))
+	if failed > 0 {
+		fmt.Printf(" (%d failed)", failed)
+	}
+
+	fmt.Println()
+	if *signal == "" || len(procs) == 0 {
+		runner.Finish()
+		return
+	}
+
+	question := fmt.Sprintf("Send SIG%s to these %d processes on %d hosts?", signalName, len(procs), len(byHost))
+	if !confirm(question) {
+		msgs.Fatalf("Not confirmed, so nothing was signalled")
+	}
+
+	if killProcesses(runner, byHost, signalName, msgs) > 0 {
+		failed++
+	}
+
+	runner.Finish()
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Parses the output of psCommand into the processes whose command line
+// matches pattern, leaving out the listing itself
+func parsePs(host, output string, pattern *regexp.Regexp) []*psProcess {
+	lines := strings.Split(strings.Replace(output, "\r", "", -1), "\n")
+	shell := strings.TrimSpace(lines[0])
+
+	var procs []*psProcess
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || fields[0] == shell || fields[1] == shell {
+			continue
+		}
+
+		// The command line is everything after the first five columns,
+		// spacing and all
+		args := line
+		for _, field := range fields[:5] {
+			args = strings.TrimLeft(args, " \t")[len(field):]
+		}
+
+		args = strings.TrimSpace(args)
+		if strings.Contains(args, psColumns) || !pattern.MatchString(args) {
+			continue
+		}
+
+		cpu, _ := strconv.ParseFloat(fields[3], 64)
+		mem, _ := strconv.ParseFloat(fields[4], 64)
+		procs = append(procs, &psProcess{host: host, pid: pid, user: fields[2], cpu: cpu, mem: mem, args: args})
+	}
+
+	return procs
+}
+
+// Gets the ordering for -sort; cpu and mem put the largest first
+func psSorter(by string) (func(a, b *psProcess) bool, error) {
+	switch by {
+	case "cpu":
+		return func(a, b *psProcess) bool { return a.cpu > b.cpu }, nil
+	case "mem":
+		return func(a, b *psProcess) bool { return a.mem > b.mem }, nil
+	case "host":
+		return func(a, b *psProcess) bool { return a.host < b.host || (a.host == b.host && a.pid < b.pid) }, nil
+	case "pid":
+		return func(a, b *psProcess) bool { return a.pid < b.pid }, nil
+	case "user":
+		return func(a, b *psProcess) bool { return a.user < b.user }, nil
+	default:
+		return nil, fmt.Errorf("Invalid -sort %s, expected cpu, mem, host, pid or user", by)
+	}
+}
+
+// Prints the processes as a table
+func printPs(out io.Writer, procs []*psProcess) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "HOST\tPID\tUSER\t%%CPU\t%%MEM\tCOMMAND\n")
+	for _, proc := range procs {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.1f\t%.1f\t%s\n", proc.host, proc.pid, proc.user, proc.cpu, proc.mem, proc.args)
+	}
+
+	w.Flush()
+}
+
+// Sends the signal to the listed processes on each host, and returns how
+// many hosts it failed on
+func killProcesses(runner *Runner, byHost map[string][]int, signal string, msgs *log.Logger) int {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	sem := make(chan bool, flagParallel)
+	failed := 0
+	for host, pids := range byHost {
+		var args []string
+		for _, pid := range pids {
+			args = append(args, strconv.Itoa(pid))
+		}
+
+		cmd := NewSSHCommand(fmt.Sprintf("kill -%s %s", signal, strings.Join(args, " ")), flagSudo, flagPty, false, flagTimeout, nil)
+		wg.Add(1)
+		go func(host string, count int) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+
+			result, code := runner.RunOne(context.Background(), host, cmd)
+			lock.Lock()
+			defer lock.Unlock()
+			if result.result != nil {
+				msgs.Printf("Failed to signal processes on %s: %s", host, result.result.Error())
+				failed++
+			} else if code != 0 {
+				msgs.Printf("Failed to signal processes on %s: %s", host, strings.TrimSpace(result.Stderr()))
+				failed++
+			} else {
+				msgs.Printf("Signalled %d processes on %s", count, host)
+			}
+		}(host, len(pids))
+	}
+
+	wg.Wait()
+	return failed
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Name of the script put-config sends along with the file
+const putConfigScriptName = "mesos-ssh-put-config.sh"
+
+// Installs the uploaded file %[1]s at %[2]s, keeping the old file's owner
+// and mode, then runs the validation command %[3]s and puts the old file back
+// if it fails.  Finally runs %[4]s to reload the service.
+const putConfigScript = `set -e
+target=%[2]s
+staged="$target.mesos-ssh-new"
+backup="$target.mesos-ssh-old"
+
+# Stage next to the target, so the rename is atomic
+cp %[1]s "$staged"
+if [ -e "$target" ]; then
+	chown --reference="$target" "$staged"
+	chmod --reference="$target" "$staged"
+	cp -p "$target" "$backup"
+fi
+mv -f "$staged" "$target"
+
+if ! ( %[3]s ); then
+	echo "Validation failed; restoring the previous $target" >&2
+	if [ -e "$backup" ]; then
+		mv -f "$backup" "$target"
+	else
+		rm -f "$target"
+	fi
+	exit 3
+fi
+
+rm -f "$backup"
+%[4]s
+`
+
+// Replaces a file on each host, validating it and rolling it back if the
+// validation fails
+func putConfigMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("put-config", flag.ExitOnError)
+	validate := fs.String("validate", "true", "Command that must succeed once the new file is in place, or it is rolled back")
+	restart := fs.String("restart", "", "Command to run after the new file is in place and valid, e.g. to reload the service")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 3 {
+		msgs.Fatalf("Usage: %s [OPTIONS] put-config <spec> <local file> <remote path> [-validate cmd] [-restart cmd]", os.Args[0])
+	}
+
+	local, remote := args[1], args[2]
+	if info, err := os.Stat(local); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	} else if !info.Mode().IsRegular() {
+		msgs.Fatalf("%s is not a regular file", local)
+	}
+
+	if filepath.Base(local) == putConfigScriptName {
+		msgs.Fatalf("Cannot send a file named %s", putConfigScriptName)
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	// The script is sent as a file, so it doesn't need quoting for sudo
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, putConfigScriptName)
+	contents := fmt.Sprintf(putConfigScript, shellQuote("./"+filepath.Base(local)), shellQuote(remote), *validate, *restart)
+	if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll, err := newCollector()
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	cmd := NewSSHCommand("/bin/sh ./"+putConfigScriptName, true, true, false, flagTimeout, []string{local, script})
+	runner.Run(context.Background(), hosts, cmd, coll)
+	coll.Finish()
+	runner.Finish()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Hosts of one cluster that runs keep failing to reach, as saved between
+// runs
+type quarantineFile struct {
+	Hosts map[string]*quarantineEntry `json:"hosts"`
+}
+
+type quarantineEntry struct {
+	// Runs in a row that couldn't reach the host
+	Failures int `json:"failures"`
+
+	// When the host was quarantined, if it is
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// Reads the quarantine file for the cluster at mesos, which is empty if it
+// doesn't exist yet
+func loadQuarantine(mesos string) (*quarantineFile, string, error) {
+	path, err := clusterFilePath("quarantine", mesos)
+	if err != nil {
+		return nil, "", err
+	}
+
+	quarantine := &quarantineFile{Hosts: make(map[string]*quarantineEntry)}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return quarantine, path, nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	if err := json.Unmarshal(contents, quarantine); err != nil {
+		return nil, "", fmt.Errorf("Failed to read %s: %s", path, err.Error())
+	}
+
+	if quarantine.Hosts == nil {
+		quarantine.Hosts = make(map[string]*quarantineEntry)
+	}
+
+	return quarantine, path, nil
+}
+
+// How long to wait for another run to finish updating the quarantine file
+const quarantineLockWait = 10 * time.Second
+
+// Locks the quarantine file at path against other runs, so that their
+// updates aren't lost.  The caller must unlock the returned file.
+func lockQuarantine(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(quarantineLockWait)
+	for {
+		file, err := lockFile(path + ".lock")
+		if err != errLocked {
+			return file, err
+		} else if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another run", path)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Saves the quarantine file to path
+func (quarantine *quarantineFile) save(path string) error {
+	contents, err := json.MarshalIndent(quarantine, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(path, contents)
+}
+
+// Checks whether a host is quarantined
+func (quarantine *quarantineFile) has(host string) bool {
+	entry, ok := quarantine.Hosts[host]
+	return ok && entry.Since != nil
+}
+
+// Records whether a run reached a host.  After the specified number of runs
+// in a row that didn't, the host is quarantined; one that did releases it.
+// Returns true if the host was just quarantined.
+func (quarantine *quarantineFile) record(host string, reached bool, after int) bool {
+	if reached {
+		delete(quarantine.Hosts, host)
+		return false
+	}
+
+	entry, ok := quarantine.Hosts[host]
+	if !ok {
+		entry = &quarantineEntry{}
+		quarantine.Hosts[host] = entry
+	}
+
+	entry.Failures++
+	if entry.Since == nil && entry.Failures >= after {
+		now := time.Now().UTC()
+		entry.Since = &now
+		return true
+	}
+
+	return false
+}
+
+// Leaves out quarantined hosts, unless -include-quarantined is given
+func skipQuarantined(hosts []string, msgs *log.Logger) ([]string, error) {
+	if flagQuarAfter <= 0 || flagIncludeQuar {
+		return hosts, nil
+	}
+
+	quarantine, _, err := loadQuarantine(flagMesos)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept, skipped []string
+	for _, host := range hosts {
+		if quarantine.has(host) {
+			skipped = append(skipped, host)
+		} else {
+			kept = append(kept, host)
+		}
+	}
+
+	if len(skipped) > 0 {
+		msgs.Printf("Leaving out %d quarantined hosts (use -include-quarantined to run on them)", len(skipped))
+		log.Printf("Quarantined hosts: %s", strings.Join(skipped, ", "))
+	}
+
+	return kept, nil
+}
+
+// Updates the quarantine file with whether the run reached each host.  Only
+// failures to connect or open a session count against a host, not commands
+// that failed, timed out or were skipped.
+func recordQuarantine(runner *Runner, hosts []string, msgs *log.Logger) {
+	if flagQuarAfter <= 0 || len(hosts) == 0 {
+		return
+	}
+
+	path, err := clusterFilePath("quarantine", flagMesos)
+	if err != nil {
+		msgs.Printf("Failed to update quarantine: %s", err.Error())
+		return
+	}
+
+	lock, err := lockQuarantine(path)
+	if err != nil {
+		msgs.Printf("Failed to update quarantine: %s", err.Error())
+		return
+	}
+
+	defer unlockFile(lock)
+
+	quarantine, path, err := loadQuarantine(flagMesos)
+	if err != nil {
+		msgs.Printf("Failed to update quarantine: %s", err.Error())
+		return
+	}
+
+	for _, host := range hosts {
+		if quarantine.record(host, !runner.Unreachable(host), flagQuarAfter) {
+			msgs.Printf("Quarantined %s after %d runs in a row that couldn't reach it", host, flagQuarAfter)
+		}
+	}
+
+	if err := quarantine.save(path); err != nil {
+		msgs.Printf("Failed to update quarantine: %s", err.Error())
+	}
+}
+
+// Lists the quarantined hosts, or releases some or all of them
+func quarantineMain(args []string, msgs *log.Logger) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	if (args[0] != "list" && args[0] != "release" && args[0] != "clear") || (args[0] == "release") != (len(args) > 1) {
+		msgs.Fatalf("Usage: %s [OPTIONS] quarantine [list|release <host>...|clear]", os.Args[0])
+	}
+
+	if args[0] != "list" {
+		path, err := clusterFilePath("quarantine", flagMesos)
+		if err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		lock, err := lockQuarantine(path)
+		if err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		defer unlockFile(lock)
+	}
+
+	quarantine, path, err := loadQuarantine(flagMesos)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	switch args[0] {
+	case "list":
+		var hosts []string
+		for host, entry := range quarantine.Hosts {
+			if entry.Since != nil {
+				hosts = append(hosts, host)
+			}
+		}
+
+		sort.Strings(hosts)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "HOST\tSINCE\tFAILED RUNS\n")
+		for _, host := range hosts {
+			entry := quarantine.Hosts[host]
+			fmt.Fprintf(w, "%s\t%s\t%d\n", host, entry.Since.Local().Format("2006-01-02 15:04"), entry.Failures)
+		}
+
+		w.Flush()
+		return
+	case "release":
+		for _, host := range args[1:] {
+			if !quarantine.has(host) {
+				msgs.Printf("%s is not quarantined", host)
+			}
+
+			delete(quarantine.Hosts, host)
+		}
+	case "clear":
+		quarantine.Hosts = make(map[string]*quarantineEntry)
+	}
+
+	if err := quarantine.save(path); err != nil {
+		msgs.Fatalf("Failed to save %s: %s", path, err.Error())
+	}
+}
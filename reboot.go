@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Prints an ID that changes every time the host boots
+const bootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+
+// How often to check whether a rebooted host is back
+const rebootPollInterval = 10 * time.Second
+
+// Reboots hosts in batches, waiting for each batch to come back healthy
+// before moving on to the next.
+func rebootMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("reboot", flag.ExitOnError)
+	batchSize := fs.Int("reboot-batch", 1, "How many hosts to reboot at once")
+	wait := fs.Duration("wait", 10*time.Minute, "How long to wait for each host to come back")
+	health := fs.String("health", "true", "Command that must succeed on a host after it comes back")
+	rebootCmd := fs.String("reboot-cmd", "/sbin/shutdown -r now", "Command used to reboot each host")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 || *batchSize < 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] reboot <spec> [-reboot-batch n] [-wait duration] [-health cmd] [-reboot-cmd cmd]", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	rebooter := &rebooter{
+		runner:    runner,
+		msgs:      msgs,
+		wait:      *wait,
+		health:    NewSSHCommand(*health, flagSudo, flagPty, false, flagTimeout, nil),
+		rebootCmd: NewSSHCommand(backgroundReboot(*rebootCmd), true, true, false, flagTimeout, nil),
+		status:    make(map[string]string),
+	}
+
+	// Reboot each batch, stopping at the first batch with a problem
+	failed := false
+	for start := 0; start < len(hosts); start += *batchSize {
+		end := start + *batchSize
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+
+		if failed {
+			for _, host := range hosts[start:end] {
+				rebooter.status[host] = "skipped"
+			}
+
+			continue
+		}
+
+		batch := hosts[start:end]
+		msgs.Printf("Rebooting %s", strings.Join(batch, ", "))
+		if !rebooter.rebootBatch(batch) {
+			msgs.Printf("Not all hosts came back healthy; skipping the remaining hosts")
+			failed = true
+		}
+	}
+
+	rebooter.printReport()
+	runner.Finish()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Wraps the reboot command to run after the session ends, so the connection
+// isn't cut off.  As in agent-restart, it always runs with sudo, so that being
+// refused shows up as an exit code rather than a host that never reboots; so
+// does a command that isn't there.
+func backgroundReboot(rebootCmd string) string {
+	check := ""
+	if fields := strings.Fields(rebootCmd); len(fields) > 0 {
+		check = "command -v " + shellQuote(fields[0]) + " >/dev/null || exit 127; "
+	}
+
+	return check + "nohup /bin/sh -c " + shellQuote("sleep 2; "+rebootCmd) + " >/dev/null 2>&1 &"
+}
+
+// State for the reboot subcommand
+type rebooter struct {
+	runner    *Runner
+	msgs      *log.Logger
+	wait      time.Duration
+	health    *SSHCommand
+	rebootCmd *SSHCommand
+
+	lock   sync.Mutex
+	status map[string]string
+}
+
+// Reboots a batch of hosts in parallel.  Returns true if they all came back
+// healthy.
+func (rb *rebooter) rebootBatch(hosts []string) bool {
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			status := rb.rebootHost(host)
+			rb.msgs.Printf("%s: %s", host, status)
+
+			rb.lock.Lock()
+			rb.status[host] = status
+			rb.lock.Unlock()
+		}(host)
+	}
+
+	wg.Wait()
+
+	for _, host := range hosts {
+		if rb.status[host] != "ok" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reboots one host and waits for it to return.  Returns its final status.
+func (rb *rebooter) rebootHost(host string) string {
+	bootID, err := rb.bootID(host)
+	if err != nil {
+		return "unreachable: " + err.Error()
+	}
+
+	result, code := rb.runner.RunOne(context.Background(), host, rb.rebootCmd)
+	if result.result == nil && code != 0 {
+		return fmt.Sprintf("reboot failed with code %d", code)
+	}
+
+	// Errors are expected if the connection drops as the host goes down, so
+	// just wait for a new boot ID.
+	deadline := time.Now().Add(rb.wait)
+	for {
+		time.Sleep(rebootPollInterval)
+		if id, err := rb.bootID(host); err == nil && id != bootID {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return "did not come back"
+		}
+	}
+
+	result, code = rb.runner.RunOne(context.Background(), host, rb.health)
+	if result.result != nil {
+		return "health check failed: " + result.result.Error()
+	} else if code != 0 {
+		return fmt.Sprintf("health check failed with code %d", code)
+	}
+
+	return "ok"
+}
+
+// Gets the host's current boot ID
+func (rb *rebooter) bootID(host string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rebootPollInterval)
+	defer cancel()
+
+	result, code := rb.runner.RunOne(ctx, host, NewSSHCommand(bootIDCommand, false, false, false, rebootPollInterval, nil))
+	if result.result != nil {
+		return "", result.result
+	} else if code != 0 {
+		return "", fmt.Errorf("%s exited with code %d", bootIDCommand, code)
+	}
+
+	return strings.TrimSpace(result.Stdout()), nil
+}
+
+// Prints the final status of every host
+func (rb *rebooter) printReport() {
+	var hosts []string
+	for host := range rb.status {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "%s\t%s\n", host, rb.status[host])
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Reads the hosts from a previous run's -print-exit-map JSON or -output json
+// output whose result matches status: "ok" (exited 0), "failed" (anything else) or "all".
+func GetHostsFromResults(path, status string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exits, err := parseExitMap(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", path, err.Error())
+	}
+
+	return hostsWithStatus(exits, status)
+}
+
+// Picks the hosts from an exit map whose result matches status: "ok",
+// "failed" or "all"
+func hostsWithStatus(exits map[string]int, status string) ([]string, error) {
+	var result []string
+	for host, code := range exits {
+		switch status {
+		case "all":
+		case "ok":
+			if code != 0 {
+				continue
+			}
+		case "failed":
+			if code == 0 || code == exitSkipped {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("Unknown status '%s', wanted ok, failed or all", status)
+		}
+
+		result = append(result, host)
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// Writes the hosts whose result matches status to path, one per line, so the
+// file can be the host spec for another run
+func exportHosts(path, status string, exits map[string]int) error {
+	hosts, err := hostsWithStatus(exits, status)
+	if err != nil {
+		return err
+	}
+
+	var contents string
+	for _, host := range hosts {
+		contents += host + "\n"
+	}
+
+	return ioutil.WriteFile(path, []byte(contents), 0644)
+}
+
+// Parses an exit map.  The whole of contents may be the JSON object, or it
+// may be the last line after the run's other output.  The records written by
+// -output json, as an array or one per line, are read as well.
+func parseExitMap(contents string) (map[string]int, error) {
+	if records, ok := parseHostRecords(contents); ok {
+		exits := make(map[string]int)
+		for _, record := range records {
+			exits[record.Host] = record.ExitCode
+		}
+
+		return exits, nil
+	}
+
+	var exits map[string]int
+	err := json.Unmarshal([]byte(contents), &exits)
+	if err == nil {
+		return exits, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(contents), "\n")
+	if len(lines) > 1 {
+		if json.Unmarshal([]byte(lines[len(lines)-1]), &exits) == nil {
+			return exits, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Parses the output of -output json: an array of HostRecords, or one per
+// line.  Returns false if contents isn't in either format.
+func parseHostRecords(contents string) ([]*HostRecord, bool) {
+	var records []*HostRecord
+	array := strings.HasPrefix(strings.TrimSpace(contents), "[")
+	if array {
+		if json.Unmarshal([]byte(contents), &records) != nil {
+			return nil, false
+		}
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(contents))
+		for decoder.More() {
+			record := &HostRecord{}
+			if decoder.Decode(record) != nil {
+				return nil, false
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	// An exit map decodes as a record without a host
+	for _, record := range records {
+		if record.Host == "" {
+			return nil, false
+		}
+	}
+
+	return records, array || len(records) > 0
+}
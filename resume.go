@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Where partly sent files are kept on the remote host between attempts
+const resumeDir = `"${XDG_CACHE_HOME:-$HOME/.cache}"/mesos-ssh`
+
+// Checksums of local files, so each is only read once per run
+var (
+	digests     = make(map[string]string)
+	digestsLock sync.Mutex
+)
+
+// Gets the SHA-256 checksum of a local file
+func fileSHA256(path string) (string, error) {
+	digestsLock.Lock()
+	defer digestsLock.Unlock()
+
+	if digest, ok := digests[path]; ok {
+		return digest, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	digests[path] = hex.EncodeToString(hash.Sum(nil))
+	return digests[path], nil
+}
+
+// Sends a file to the specified directory on the remote host so that, if the
+// connection drops, the next attempt carries on from where this one stopped.
+// The file is built up in a cache directory named after its checksum, checked,
+// then copied into place.
+func (sesh *SSHSession) sendResumable(dir, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	digest, err := fileSHA256(file)
+	if err != nil {
+		return err
+	}
+
+	partial := resumeDir + "/" + digest + ".part"
+
+	// See how much arrived last time
+	out, err := sesh.output(fmt.Sprintf(`mkdir -p %s && chmod 700 %s && { stat -c %%s %s 2>/dev/null || echo 0; }`, resumeDir, resumeDir, partial))
+	if err != nil {
+		return fmt.Errorf("Failed to check for a partial copy of %s: %s", file, err.Error())
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil || offset > info.Size() {
+		offset = 0
+	}
+
+	if offset > 0 {
+		log.Printf("Resuming %s on %s from byte %d", file, sesh.Host, offset)
+	}
+
+	if offset < info.Size() {
+		if err := sesh.appendFrom(file, offset, partial); err != nil {
+			return fmt.Errorf("Failed to send %s: %s", file, err.Error())
+		}
+	}
+
+	// Check the whole file, then move it into place.  A bad copy is thrown
+	// away so that the next attempt starts over.
+	target := shellQuote(dir + "/" + filepath.Base(file))
+	install := fmt.Sprintf(`if [ "$(sha256sum < %[1]s | cut -d' ' -f1)" = %[2]s ]; then mv %[1]s %[3]s && chmod %04[4]o %[3]s; else rm -f %[1]s; echo "checksum mismatch"; exit 1; fi`,
+		partial, digest, target, info.Mode().Perm())
+	if out, err := sesh.output(install); err != nil {
+		return fmt.Errorf("Failed to install %s: %s: %s", file, err.Error(), strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Appends a local file, starting at offset, to a remote file
+func (sesh *SSHSession) appendFrom(file string, offset int64, remote string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+	session.Stdin = sesh.uploadReader(f, filepath.Base(file), info.Size()-offset)
+	return session.Run("cat >> " + remote)
+}
+
+// Runs a command and returns its combined output
+func (sesh *SSHSession) output(command string) (string, error) {
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	defer session.Close()
+	out, err := session.CombinedOutput(command)
+	return string(out), err
+}
+
+// Checks that the files sent to the specified directory on the remote host
+// have the same SHA-256 checksums as the local files
+func (sesh *SSHSession) verifyFiles(dir string, files []string) error {
+	var quoted []string
+	for _, file := range files {
+		quoted = append(quoted, shellQuote(filepath.Base(file)))
+	}
+
+	// One line per file, in order, even for files that can't be read
+	command := fmt.Sprintf(`cd %s && for f in %s; do sha256sum < "$f" || echo '(unreadable)'; done`, shellQuote(dir), strings.Join(quoted, " "))
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+	output, err := session.Output(command)
+	if err != nil {
+		return fmt.Errorf("Failed to check sent files: %s", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) != len(files) {
+		return fmt.Errorf("Failed to check sent files: expected %d checksums, got %d", len(files), len(lines))
+	}
+
+	for i, file := range files {
+		digest, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+
+		// sha256sum follows the digest with "-" for stdin
+		remote := strings.Fields(lines[i])
+		if len(remote) == 0 {
+			remote = []string{"(nothing)"}
+		}
+
+		if remote[0] != digest {
+			return fmt.Errorf("Checksum of %s on the host doesn't match: sent %s, got %s", filepath.Base(file), digest, remote[0])
+		}
+	}
+
+	log.Printf("Verified %d files on %s", len(files), sesh.Host)
+	return nil
+}
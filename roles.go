@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Everything known about one role
+type roleReport struct {
+	name       string
+	weight     float64
+	frameworks int
+	agents     int
+	guarantee  string
+	limit      string
+}
+
+// Reports each role's weight, quota and how many agents have resources
+// reserved for it
+func rolesMain(args []string, msgs *log.Logger) {
+	if len(args) != 0 {
+		msgs.Fatalf("Usage: %s [OPTIONS] roles", os.Args[0])
+	}
+
+	client, err := getMesosClient(flagMesos, msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find Mesos: %s", err.Error())
+	}
+
+	roles, err := client.GetRoles()
+	if err != nil {
+		msgs.Fatalf("Failed to get roles: %s", err.Error())
+	}
+
+	quota, err := client.GetQuota()
+	if err != nil {
+		msgs.Fatalf("Failed to get quota: %s", err.Error())
+	}
+
+	agents, err := client.GetAgents()
+	if err != nil {
+		msgs.Fatalf("Failed to get agents: %s", err.Error())
+	}
+
+	printRoles(os.Stdout, buildRoleReports(roles, quota, agents))
+}
+
+// Combines what Mesos reports about roles, quotas and agents
+func buildRoleReports(roles *MesosRolesResponse, quota *MesosQuotaResponse, agents *MesosAgentsResponse) []*roleReport {
+	reports := make(map[string]*roleReport)
+	get := func(name string) *roleReport {
+		if report, ok := reports[name]; ok {
+			return report
+		}
+
+		report := &roleReport{name: name, weight: 1}
+		reports[name] = report
+		return report
+	}
+
+	if roles != nil {
+		for _, role := range roles.Roles {
+			report := get(role.Name)
+			report.weight = role.Weight
+			report.frameworks = len(role.Frameworks)
+		}
+	}
+
+	if quota != nil {
+		for _, info := range quota.Status.Infos {
+			get(info.Role).guarantee = formatResources(info.Guarantee)
+		}
+
+		for _, config := range quota.Status.Configs {
+			report := get(config.Role)
+			report.guarantee = formatScalars(config.Guarantees)
+			report.limit = formatScalars(config.Limits)
+		}
+	}
+
+	if agents != nil {
+		for _, agent := range agents.Agents {
+			seen := make(map[string]bool)
+			for _, resource := range agent.TotalResources {
+				if resource.Role != "" && resource.Role != "*" && !seen[resource.Role] {
+					seen[resource.Role] = true
+					get(resource.Role).agents++
+				}
+			}
+		}
+	}
+
+	var result []*roleReport
+	for _, report := range reports {
+		result = append(result, report)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+// Formats scalar resources, e.g. "cpus:4 mem:1024"
+func formatResources(resources []*MesosResource) string {
+	scalars := make(map[string]MesosScalar)
+	for _, resource := range resources {
+		scalars[resource.Name] = MesosScalar{Value: scalars[resource.Name].Value + resource.Scalar.Value}
+	}
+
+	return formatScalars(scalars)
+}
+
+// Formats named scalars, e.g. "cpus:4 mem:1024"
+func formatScalars(scalars map[string]MesosScalar) string {
+	var result []string
+	for name, scalar := range scalars {
+		result = append(result, fmt.Sprintf("%s:%g", name, scalar.Value))
+	}
+
+	if len(result) == 0 {
+		return "-"
+	}
+
+	sort.Strings(result)
+	return strings.Join(result, " ")
+}
+
+// Prints the roles as a table
+func printRoles(out io.Writer, reports []*roleReport) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ROLE\tWEIGHT\tFRAMEWORKS\tAGENTS\tGUARANTEE\tLIMIT\n")
+	for _, report := range reports {
+		guarantee, limit := report.guarantee, report.limit
+		if guarantee == "" {
+			guarantee = "-"
+		}
+
+		if limit == "" {
+			limit = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%g\t%d\t%d\t%s\t%s\n", report.name, report.weight, report.frameworks, report.agents, guarantee, limit)
+	}
+
+	w.Flush()
+}
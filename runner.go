@@ -0,0 +1,822 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identifies this run, e.g. in the names of remote temporary directories
+var runID = newRunID()
+
+// Makes a run ID from the time and some random bytes
+func newRunID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", time.Now().Format("20060102T150405"), b)
+}
+
+// Identifies the operator as user.hostname, using only characters that are
+// safe in file names
+func operatorName() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		name += "." + hostname
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+// Runs commands on many hosts in parallel, using the settings from the
+// command line.
+type Runner struct {
+	auth      *Auth
+	verify    *HostKeyVerifier
+	hostKeys  *HostKeyReport
+	onFailure *FailureHook
+	jump      *JumpHost
+
+	// Per-host options from ~/.ssh/config, with the auth and jump hosts
+	// they call for
+	sshConfig *SSHConfig
+	msgs      *log.Logger
+	passwords passwordStores
+	keyAuths  map[string]*Auth
+	jumps     map[string]*JumpHost
+
+	// Where end-of-run reports go
+	report io.Writer
+
+	// Where each host's result is exported, if anywhere
+	export *ESExporter
+
+	// Creates the Transport for each host
+	dial func(host string, remote *RemoteIO) Transport
+
+	// Opens direct connections, to hosts or jump hosts
+	netDial DialFunc
+
+	// If set, hosts whose OS doesn't match are skipped
+	onlyOS *regexp.Regexp
+
+	// If set, hosts that run commands without sudo even with -sudo
+	noSudo *HostRules
+
+	// If set, hosts with timeouts other than -timeout
+	timeouts *HostTimeouts
+
+	// Slots for sudo sessions, if -sudo-concurrency limits them
+	sudoSlots chan struct{}
+
+	// Shared by every upload, if -bwlimit limits them
+	uploads *rateLimiter
+
+	// Each host's group for -progress to break counts down by, if any
+	progressGroups map[string]string
+
+	lock  sync.Mutex
+	exits map[string]int
+	notes map[string][]string
+	osLog map[string]string
+
+	// Hosts that couldn't be reached, for -quarantine-after
+	unreachable map[string]bool
+}
+
+// Cheap command that identifies a host's OS
+const osProbe = "uname -sr"
+
+// Exit code recorded for hosts that -only-os or -require-cmd skipped, which
+// count as neither succeeding nor failing
+const exitSkipped = -2
+
+// Checks whether a host's result counts as a failure
+func hostFailed(code int, err error) bool {
+	return err != nil || (code != 0 && code != exitSkipped)
+}
+
+// Sets up authentication, host key checking and hooks from the command line
+// flags.
+func NewRunner(msgs *log.Logger) (*Runner, error) {
+	runner := &Runner{
+		msgs:     msgs,
+		report:   os.Stdout,
+		keyAuths: make(map[string]*Auth),
+		jumps:    make(map[string]*JumpHost),
+		exits:    make(map[string]int),
+		notes:    make(map[string][]string),
+		osLog:    make(map[string]string),
+
+		unreachable: make(map[string]bool),
+	}
+
+	// Set up authentication
+	var passwords passwordStores
+	if flagCacheTTL > 0 {
+		passwords = append(passwords, NewCredCache(flagMesos, flagUser, flagCacheTTL))
+	}
+
+	if flagUseKeyring {
+		passwords = append(passwords, NewKeyring(flagMesos, flagUser))
+	}
+
+	runner.passwords = passwords
+
+	auth, err := NewAuth(authOptions(flagKeyfile, passwords), msgs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize auth: %s", err.Error())
+	}
+
+	runner.auth = auth
+
+	// Restrict what gets forwarded
+	if flagForwardAgent && (len(flagForwardIds) > 0 || flagForwardLife > 0 || flagForwardConf) {
+		if err := auth.ConstrainForwarding(flagForwardIds, flagForwardLife, flagForwardConf); err != nil {
+			return nil, fmt.Errorf("Failed to set up agent forwarding: %s", err.Error())
+		}
+	}
+
+	// Set up failure hook
+	if flagOnFailure != "" {
+		runner.onFailure, err = NewFailureHook(flagOnFailure, msgs)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -on-failure-exec: %s", err.Error())
+		}
+	}
+
+	// Set up host key verification
+	policy := flagKeyPolicy
+	if flagInsecureKeys {
+		policy = "insecure"
+	}
+
+	runner.verify, err = NewHostKeyVerifier(policy, flagKnownHosts, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize host key verification: %s", err.Error())
+	}
+
+	// Record host keys if requested
+	if flagReportKeys {
+		runner.hostKeys = NewHostKeyReport()
+	}
+
+	if flagOnlyOS != "" {
+		runner.onlyOS, err = regexp.Compile(flagOnlyOS)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -only-os: %s", err.Error())
+		}
+	}
+
+	// Connections are made with the -bind-addr, -keepalive and -dscp options
+	dialer, err := newDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	runner.netDial = dialer.DialContext
+
+	// Tunnel through the jump host, which may authenticate differently
+	var dial DialFunc
+	if flagJump != "" {
+		jumpAuth := auth
+		if flagJumpKey != "" {
+			options := authOptions(flagJumpKey, passwords)
+			options.ForwardAgent = false
+			jumpAuth, err = NewAuth(options, msgs)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to initialize jump host auth: %s", err.Error())
+			}
+		}
+
+		runner.jump, err = NewJumpHost(flagJump, flagUser, 22, jumpAuth, runner.verify.Check, runner.netDial)
+		if err != nil {
+			return nil, err
+		}
+
+		dial = runner.jump.Dial
+	}
+
+	if _, err := hostsWithStatus(nil, flagExportStatus); err != nil {
+		return nil, fmt.Errorf("Invalid -export-status: %s", err.Error())
+	}
+
+	if flagHostBusy != "queue" && flagHostBusy != "reject" {
+		return nil, fmt.Errorf("Unknown -host-busy %s", flagHostBusy)
+	}
+
+	if len(flagNoSudoOn) > 0 {
+		if runner.noSudo, err = NewHostRules("no-sudo-on", flagNoSudoOn, msgs); err != nil {
+			return nil, err
+		}
+	}
+
+	if flagSudoConc > 0 {
+		runner.sudoSlots = make(chan struct{}, flagSudoConc)
+	}
+
+	runner.uploads = newRateLimiter(flagBWLimit << 10)
+
+	if flagProgress > 0 {
+		if runner.progressGroups, err = progressGroups(msgs); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(flagTimeoutOn) > 0 || flagMasterTime > 0 || flagAgentTime > 0 {
+		if runner.timeouts, err = NewHostTimeouts(msgs); err != nil {
+			return nil, err
+		}
+	}
+
+	if flagESURL != "" {
+		if runner.export, err = NewESExporter(flagESURL, flagESIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read per-host settings from ssh's config
+	runner.sshConfig = &SSHConfig{}
+	if flagSSHConfig != "none" {
+		runner.sshConfig, err = NewSSHConfig(expandHome(flagSSHConfig))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %s", flagSSHConfig, err.Error())
+		}
+	}
+
+	runner.dial = func(host string, remote *RemoteIO) Transport {
+		return runner.newSession(host, remote, dial)
+	}
+
+	return runner, nil
+}
+
+// Gets the AuthOptions from the command line, with keyFile as the private
+// key
+func authOptions(keyFile string, passwords passwordStores) *AuthOptions {
+	return &AuthOptions{
+		PrivateKey:       keyFile,
+		PasswordFile:     flagPasswordFile,
+		AgentSocket:      flagAgentSocket,
+		ForwardAgent:     flagForwardAgent,
+		AgentAuth:        !flagNoAgent,
+		AgentConcurrency: flagAgentConc,
+		AgentKeys:        flagAgentKeys,
+		Stores:           passwords,
+		PromptTimeout:    flagPassTimeout,
+	}
+}
+
+// Creates the SSHSession for a host, applying its options from ssh's config
+// where the command line doesn't override them.  dial is the jump host from
+// the command line, if any.
+func (runner *Runner) newSession(host string, remote *RemoteIO, dial DialFunc) Transport {
+	// Hosts may be given as user@host:port, which overrides everything else
+	target, err := ParseHostSpec(host)
+	if err != nil {
+		target = &HostSpec{Host: host}
+	}
+
+	options := runner.sshConfig.Lookup(target.Host)
+
+	user, port, auth := flagUser, flagPort, runner.auth
+	if target.User != "" {
+		user = target.User
+	} else if options.User != "" && !flagWasSet("user") {
+		user = options.User
+	}
+
+	if target.Port != 0 {
+		port = target.Port
+	} else if options.Port != 0 && !flagWasSet("port") {
+		port = options.Port
+	}
+
+	if options.IdentityFile != "" && flagKeyfile == "" {
+		auth = runner.authFor(options.IdentityFile)
+	}
+
+	if options.ProxyJump != "" && dial == nil {
+		if jump := runner.jumpFor(options.ProxyJump); jump != nil {
+			dial = jump.Dial
+		}
+	}
+
+	if dial == nil {
+		dial = runner.netDial
+	}
+
+	sesh := NewSSHSession(host, user, port, auth, remote, runner.verify.Check, runner.hostKeys, dial)
+	sesh.limiter = runner.uploads
+	sesh.Address = options.HostName
+	if sesh.Address == "" && target.Host != host {
+		sesh.Address = target.Host
+	}
+
+	return sesh
+}
+
+// Gets the Auth for an IdentityFile from ssh's config, falling back to the
+// usual one if the key can't be used
+func (runner *Runner) authFor(keyFile string) *Auth {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if auth, ok := runner.keyAuths[keyFile]; ok {
+		return auth
+	}
+
+	auth, err := NewAuth(authOptions(keyFile, runner.passwords), runner.msgs)
+	if err != nil {
+		runner.msgs.Printf("Failed to use IdentityFile %s, using the usual keys: %s", keyFile, err.Error())
+		auth = runner.auth
+	}
+
+	runner.keyAuths[keyFile] = auth
+	return auth
+}
+
+// Gets the JumpHost for a ProxyJump from ssh's config.  Only a single hop is
+// supported.
+func (runner *Runner) jumpFor(spec string) *JumpHost {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if jump, ok := runner.jumps[spec]; ok {
+		return jump
+	}
+
+	if strings.Contains(spec, ",") {
+		runner.msgs.Printf("Only the first hop of ProxyJump %s is used", spec)
+	}
+
+	// The jump host may have its own entry in ssh's config
+	hop := strings.Split(spec, ",")[0]
+	name, user, port := hop, flagUser, 22
+	if at := strings.LastIndex(name, "@"); at >= 0 {
+		user, name = name[:at], name[at+1:]
+	} else if options := runner.sshConfig.Lookup(name); options.User != "" {
+		user = options.User
+	}
+
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		name = h
+		port, _ = strconv.Atoi(p)
+	} else if options := runner.sshConfig.Lookup(name); options.Port != 0 {
+		port = options.Port
+	}
+
+	if options := runner.sshConfig.Lookup(name); options.HostName != "" {
+		name = options.HostName
+	}
+
+	jump, err := NewJumpHost(user+"@"+net.JoinHostPort(name, strconv.Itoa(port)), flagUser, 22, runner.auth, runner.verify.Check, runner.netDial)
+	if err != nil {
+		runner.msgs.Printf("Invalid ProxyJump %s: %s", spec, err.Error())
+	}
+
+	runner.jumps[spec] = jump
+	return jump
+}
+
+// Runs cmd on every host, at most -m at a time, sending output to coll.
+// Returns once every host has finished and coll has displayed the results.
+// Cancelling ctx closes any open connections, and hosts that have not
+// started yet fail with ctx's error.
+func (runner *Runner) Run(ctx context.Context, hosts []string, cmd *SSHCommand, coll IOCollector) {
+	// Semaphore for parallel sessions
+	sem := make(chan bool, flagParallel)
+	var wg sync.WaitGroup
+
+	// Report on the run while it goes, if asked to
+	var progress *runProgress
+	if flagProgress > 0 {
+		progress = newRunProgress(hosts, runner.progressGroups)
+		stop := make(chan struct{})
+		defer close(stop)
+		go progress.Report(flagProgress, runner.msgs, stop)
+	}
+
+	// Start goroutines
+	for _, host := range hosts {
+		remote := coll.NewRemote(host)
+		if runner.export != nil {
+			remote.export = &exportOutput{}
+		}
+
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+
+			// Wait on semaphore, release when done
+			sem <- true
+			defer func() { <-sem }()
+
+			if progress != nil {
+				progress.Start(host)
+			}
+
+			remote.Start()
+			code, err := runner.runHost(ctx, host, remote, cmd)
+			remote.Done(err)
+			failed := hostFailed(code, err)
+			if progress != nil {
+				progress.Finish(host, failed)
+			}
+			runner.recordExit(host, code, err)
+			if runner.export != nil {
+				runner.export.Add(runner.exportDoc(host, cmd, remote, code, err))
+			}
+
+			runner.recordNotes(host, remote.Notes())
+			if runner.onFailure != nil && failed {
+				runner.onFailure.Fire(host, code, err)
+			}
+		}(host)
+	}
+
+	// Read back results.
+	log.Println("Reading the results")
+	coll.Read()
+
+	// Wait for all to be done.
+	log.Println("Waiting for completion")
+	wg.Wait()
+}
+
+// Runs cmd on a single host and returns its output and exit status, without
+// firing hooks or recording the result.  Used by subcommands that need to
+// inspect individual hosts.
+func (runner *Runner) RunOne(ctx context.Context, host string, cmd *SSHCommand) (*IOResult, int) {
+	coll := NewCaptureIOCollector()
+	remote := coll.NewRemote(host)
+	code := -1
+	go func() {
+		var err error
+		code, err = runner.runHost(ctx, host, remote, cmd)
+		remote.Done(err)
+	}()
+
+	coll.Read()
+	return coll.Results[0], code
+}
+
+// Connects to a host, for callers that need more than running a command.
+// The caller must close the returned Transport.
+func (runner *Runner) Connect(ctx context.Context, host string) (Transport, error) {
+	transport := runner.dial(host, NewRemoteIO(host))
+	if err := transport.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return transport, nil
+}
+
+// Connects to a host, runs cmd and disconnects.  Returns the exit code, -1 if
+// the command did not complete, or exitSkipped if the host was skipped.
+func (runner *Runner) runHost(ctx context.Context, host string, remote *RemoteIO, cmd *SSHCommand) (int, error) {
+	// Hosts still queued when the run is cancelled don't start
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	if flagMaxPerHost > 0 {
+		lock, err := acquireHostLock(ctx, host, remote)
+		if err != nil {
+			return -1, err
+		}
+
+		defer lock.Release()
+	}
+
+	transport := runner.dial(host, remote)
+	if sesh, ok := transport.(*SSHSession); ok && flagShowBanner {
+		sesh.Config.BannerCallback = func(message string) error {
+			if !strings.HasSuffix(message, "\n") {
+				message += "\n"
+			}
+
+			remote.Status("Banner:\n" + message)
+			return nil
+		}
+	}
+
+	if err := transport.Connect(ctx); err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+
+		return -1, &connectError{err}
+	}
+
+	defer transport.Close()
+	remote.Connected()
+
+	if flagNoBanner {
+		skipMOTD(ctx, transport, remote)
+	}
+
+	if flagDetectOS || runner.onlyOS != nil {
+		output, err := transport.Output(ctx, osProbe)
+		if err != nil {
+			return -1, fmt.Errorf("Failed to detect OS: %s", err.Error())
+		}
+
+		hostOS := strings.TrimSpace(output)
+		runner.recordOS(host, hostOS)
+		if runner.onlyOS != nil && !runner.onlyOS.MatchString(hostOS) {
+			remote.Status(fmt.Sprintf("Skipped, since %s does not match -only-os\n", hostOS))
+			return exitSkipped, nil
+		}
+	}
+
+	if len(flagRequireCmds) > 0 {
+		missing, err := missingCommands(ctx, transport, flagRequireCmds)
+		if err != nil {
+			return -1, fmt.Errorf("Failed to check -require-cmd: %s", err.Error())
+		}
+
+		if len(missing) > 0 {
+			remote.Status(fmt.Sprintf("Skipped, since required commands are missing: %s\n", strings.Join(missing, ", ")))
+			return exitSkipped, nil
+		}
+	}
+
+	cmd = runner.hostCommand(host, cmd)
+	if cmd.Sudo && runner.sudoSlots != nil {
+		select {
+		case runner.sudoSlots <- struct{}{}:
+		default:
+			remote.Status("Waiting for other sudo sessions to finish\n")
+			select {
+			case runner.sudoSlots <- struct{}{}:
+			case <-ctx.Done():
+				return -1, ctx.Err()
+			}
+		}
+
+		defer func() { <-runner.sudoSlots }()
+	}
+
+	return transport.RunCommand(ctx, cmd)
+}
+
+// Adjusts the command for one host, following -no-sudo-on and the
+// per-host timeouts
+func (runner *Runner) hostCommand(host string, cmd *SSHCommand) *SSHCommand {
+	adjusted := *cmd
+	if cmd.Sudo && runner.noSudo != nil && runner.noSudo.Match(host) {
+		log.Printf("Running without sudo on %s", host)
+		adjusted.Sudo = false
+	}
+
+	if runner.timeouts != nil {
+		adjusted.Timeout = runner.timeouts.For(host, cmd.Timeout)
+	}
+
+	return &adjusted
+}
+
+// Finds which of names can't be found in the remote PATH
+func missingCommands(ctx context.Context, transport Transport, names []string) ([]string, error) {
+	var probe []string
+	for _, name := range names {
+		quoted := shellQuote(name)
+		probe = append(probe, fmt.Sprintf("command -v %s >/dev/null 2>&1 || echo %s", quoted, quoted))
+	}
+
+	output, err := transport.Output(ctx, strings.Join(probe, "; "))
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(output), nil
+}
+
+// Waits for outstanding hooks, closes the jump host connection and prints
+// end-of-run reports
+func (runner *Runner) Finish() {
+	if runner.jump != nil {
+		runner.jump.Close()
+	}
+
+	for _, jump := range runner.jumps {
+		if jump != nil {
+			jump.Close()
+		}
+	}
+
+	if runner.onFailure != nil {
+		runner.onFailure.Wait()
+	}
+
+	if runner.export != nil {
+		runner.export.Flush(runner.msgs)
+	}
+
+	runner.printNotes()
+	runner.printOS()
+	runner.verify.Print(runner.report)
+
+	if runner.hostKeys != nil {
+		fmt.Fprintln(runner.report)
+		runner.hostKeys.Print(runner.report)
+	}
+
+	if flagExitMap {
+		runner.printExitMap()
+	}
+
+	if flagExportHosts != "" {
+		runner.lock.Lock()
+		err := exportHosts(flagExportHosts, flagExportStatus, runner.exits)
+		runner.lock.Unlock()
+		if err != nil {
+			runner.msgs.Printf("Failed to write -export-hosts: %s", err.Error())
+		}
+	}
+}
+
+// Makes the document exported for a host once it is done
+func (runner *Runner) exportDoc(host string, cmd *SSHCommand, remote *RemoteIO, code int, err error) *exportDoc {
+	runner.lock.Lock()
+	hostOS := runner.osLog[host]
+	runner.lock.Unlock()
+
+	doc := &exportDoc{
+		Timestamp:  remote.started.UTC(),
+		RunID:      runID,
+		Operator:   operatorName(),
+		Command:    cmd.Command,
+		Host:       host,
+		OS:         hostOS,
+		ExitCode:   code,
+		Started:    remote.started.UTC(),
+		Finished:   remote.finished.UTC(),
+		DurationMs: int64(remote.finished.Sub(remote.started) / time.Millisecond),
+		Stdout:     remote.export.stdout.String(),
+		Stderr:     remote.export.stderr.String(),
+		Truncated:  remote.export.truncated,
+	}
+
+	if err != nil {
+		doc.Error = err.Error()
+	}
+
+	return doc
+}
+
+// Records a host's exit code, -1 if the command did not complete or
+// exitSkipped if the host was skipped, and whether err means the host
+// couldn't be reached
+func (runner *Runner) recordExit(host string, code int, err error) {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+	runner.exits[host] = code
+	runner.unreachable[host] = isConnectError(err)
+}
+
+// Checks whether a host couldn't be reached: connecting to it or opening a
+// session on it failed
+func (runner *Runner) Unreachable(host string) bool {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+	return runner.unreachable[host]
+}
+
+// Gets a host's exit code, -1 if the command did not complete
+func (runner *Runner) ExitCode(host string) int {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if code, ok := runner.exits[host]; ok {
+		return code
+	}
+
+	return -1
+}
+
+// Counts how many of the hosts succeeded, failed and were skipped
+func (runner *Runner) Summary(hosts []string) (ok, failed, skipped int) {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	for _, host := range hosts {
+		code, ran := runner.exits[host]
+		if !ran {
+			code = -1
+		}
+
+		switch code {
+		case 0:
+			ok++
+		case exitSkipped:
+			skipped++
+		default:
+			failed++
+		}
+	}
+
+	return ok, failed, skipped
+}
+
+// Records the notes a host printed
+func (runner *Runner) recordNotes(host string, notes []string) {
+	if len(notes) == 0 {
+		return
+	}
+
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+	runner.notes[host] = notes
+}
+
+// Prints the notes from every host, if there were any
+func (runner *Runner) printNotes() {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if len(runner.notes) == 0 {
+		return
+	}
+
+	var hosts []string
+	for host := range runner.notes {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	fmt.Fprintf(runner.report, "\n===== Notes\n")
+	for _, host := range hosts {
+		for _, note := range runner.notes[host] {
+			fmt.Fprintf(runner.report, "%s: %s\n", host, note)
+		}
+	}
+}
+
+// Records the OS detected on a host
+func (runner *Runner) recordOS(host, hostOS string) {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+	runner.osLog[host] = hostOS
+}
+
+// Prints how many hosts run each OS, if it was detected
+func (runner *Runner) printOS() {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if len(runner.osLog) == 0 {
+		return
+	}
+
+	groups := make(map[string][]string)
+	for host, hostOS := range runner.osLog {
+		groups[hostOS] = append(groups[hostOS], host)
+	}
+
+	fmt.Fprintf(runner.report, "\n===== Operating systems\n")
+	printHistogram(runner.report, "OS", groups)
+}
+
+// Prints the exit code of every host on a single line
+func (runner *Runner) printExitMap() {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if flagExitMapFormat == "json" {
+		// Map keys are sorted by the encoder
+		json.NewEncoder(os.Stdout).Encode(runner.exits)
+		return
+	}
+
+	var pairs []string
+	for host, code := range runner.exits {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", host, code))
+	}
+
+	sort.Strings(pairs)
+	fmt.Println(strings.Join(pairs, ","))
+}
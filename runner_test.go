@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// What a fake host does when it is connected to and run on
+type fakeHost struct {
+	connectErr error
+
+	// Output answers by command, for probes such as osProbe, and the
+	// commands missing from the PATH
+	outputs   map[string]string
+	missing   []string
+	outputErr error
+
+	stdout []string
+	stderr []string
+	code   int
+	runErr error
+
+	// Waits for the run to be cancelled before finishing the command
+	block bool
+}
+
+// Hosts for a fake Transport to reach, recording what was done to them
+type fakeCluster struct {
+	hosts map[string]*fakeHost
+
+	// Sent each host as its command starts, if set
+	started chan string
+
+	lock      sync.Mutex
+	connected []string
+	ran       []string
+	running   int
+	most      int
+}
+
+// Transport that plays back a fakeHost in place of an SSH connection
+type fakeTransport struct {
+	cluster *fakeCluster
+	host    string
+	remote  *RemoteIO
+}
+
+func (fake *fakeTransport) Connect(ctx context.Context) error {
+	fake.cluster.lock.Lock()
+	defer fake.cluster.lock.Unlock()
+
+	fake.cluster.connected = append(fake.cluster.connected, fake.host)
+	host, ok := fake.cluster.hosts[fake.host]
+	if !ok {
+		return fmt.Errorf("No such host %s", fake.host)
+	}
+
+	return host.connectErr
+}
+
+func (fake *fakeTransport) PutFiles(ctx context.Context, dir string, files []string) error {
+	return nil
+}
+
+func (fake *fakeTransport) RunCommand(ctx context.Context, cmd *SSHCommand) (int, error) {
+	host := fake.cluster.hosts[fake.host]
+	fake.cluster.lock.Lock()
+	fake.cluster.ran = append(fake.cluster.ran, fake.host)
+	fake.cluster.running++
+	if fake.cluster.running > fake.cluster.most {
+		fake.cluster.most = fake.cluster.running
+	}
+	fake.cluster.lock.Unlock()
+
+	defer func() {
+		fake.cluster.lock.Lock()
+		fake.cluster.running--
+		fake.cluster.lock.Unlock()
+	}()
+
+	if fake.cluster.started != nil {
+		fake.cluster.started <- fake.host
+	}
+
+	for _, line := range host.stdout {
+		fake.remote.Stdout([]byte(line))
+	}
+
+	for _, line := range host.stderr {
+		fake.remote.Stderr([]byte(line))
+	}
+
+	if host.block {
+		<-ctx.Done()
+		return -1, ctx.Err()
+	}
+
+	if host.runErr != nil {
+		return -1, host.runErr
+	}
+
+	fake.remote.Exit(host.code)
+	return host.code, nil
+}
+
+func (fake *fakeTransport) Output(ctx context.Context, command string) (string, error) {
+	host := fake.cluster.hosts[fake.host]
+	if host.outputErr != nil {
+		return "", host.outputErr
+	}
+
+	// Probes from missingCommands name each command they check for
+	if strings.Contains(command, "command -v") {
+		var missing []string
+		for _, name := range host.missing {
+			if strings.Contains(command, "command -v "+shellQuote(name)+" ") {
+				missing = append(missing, name)
+			}
+		}
+
+		return strings.Join(missing, "\n"), nil
+	}
+
+	return host.outputs[command], nil
+}
+
+func (fake *fakeTransport) Close() error {
+	return nil
+}
+
+// Makes a Runner that reaches the cluster's hosts through fakeTransports
+func newFakeRunner(cluster *fakeCluster) *Runner {
+	runner := &Runner{
+		msgs:        log.New(ioutil.Discard, "", 0),
+		report:      ioutil.Discard,
+		keyAuths:    make(map[string]*Auth),
+		jumps:       make(map[string]*JumpHost),
+		exits:       make(map[string]int),
+		notes:       make(map[string][]string),
+		osLog:       make(map[string]string),
+		unreachable: make(map[string]bool),
+	}
+
+	runner.dial = func(host string, remote *RemoteIO) Transport {
+		return &fakeTransport{cluster, host, remote}
+	}
+
+	return runner
+}
+
+// Runs cmd on every host of the cluster, returning each host's result
+func runFake(ctx context.Context, runner *Runner, hosts []string) map[string]*IOResult {
+	coll := NewCaptureIOCollector()
+	runner.Run(ctx, hosts, NewSSHCommand("true", false, false, false, 0, nil), coll)
+
+	results := make(map[string]*IOResult)
+	for _, result := range coll.Results {
+		results[result.host] = result
+	}
+
+	return results
+}
+
+func TestRunKeepsOutputInOrder(t *testing.T) {
+	var stdout []string
+	for i := 0; i < 200; i++ {
+		stdout = append(stdout, fmt.Sprintf("line %d\n", i))
+	}
+
+	cluster := &fakeCluster{hosts: make(map[string]*fakeHost)}
+	var hosts []string
+	for i := 0; i < 10; i++ {
+		host := fmt.Sprintf("host%d", i)
+		hosts = append(hosts, host)
+		cluster.hosts[host] = &fakeHost{stdout: stdout, stderr: []string{host + " err\n"}}
+	}
+
+	results := runFake(context.Background(), newFakeRunner(cluster), hosts)
+	for _, host := range hosts {
+		result := results[host]
+		if result == nil {
+			t.Fatalf("No result from %s", host)
+		}
+
+		if got := result.Stdout(); got != strings.Join(stdout, "") {
+			t.Errorf("%s: stdout out of order: %q", host, got)
+		}
+
+		if got := result.Stderr(); got != host+" err\n" {
+			t.Errorf("%s: stderr is %q", host, got)
+		}
+	}
+
+	if cluster.most > flagParallel {
+		t.Errorf("%d commands ran at once, wanted at most -m %d", cluster.most, flagParallel)
+	}
+}
+
+func TestRunCollectsExitCodes(t *testing.T) {
+	cluster := &fakeCluster{hosts: map[string]*fakeHost{
+		"ok":      {},
+		"failed":  {code: 3},
+		"broken":  {runErr: errors.New("Session closed")},
+		"refused": {connectErr: errors.New("Connection refused")},
+	}}
+
+	runner := newFakeRunner(cluster)
+	results := runFake(context.Background(), runner, []string{"ok", "failed", "broken", "refused"})
+
+	want := map[string]int{"ok": 0, "failed": 3, "broken": -1, "refused": -1}
+	for host, code := range want {
+		if got := runner.ExitCode(host); got != code {
+			t.Errorf("%s: exit code %d, wanted %d", host, got, code)
+		}
+	}
+
+	if results["ok"].result != nil || results["failed"].result != nil {
+		t.Errorf("Commands that exited reported errors: %v, %v", results["ok"].result, results["failed"].result)
+	}
+
+	if results["broken"].result == nil || results["refused"].result == nil {
+		t.Errorf("Commands that didn't complete reported no error")
+	}
+
+	if ok, failed, skipped := runner.Summary([]string{"ok", "failed", "broken", "refused"}); ok != 1 || failed != 3 || skipped != 0 {
+		t.Errorf("Summary is %d ok, %d failed, %d skipped, wanted 1, 3, 0", ok, failed, skipped)
+	}
+
+	if runner.ExitCode("missing") != -1 {
+		t.Errorf("A host that didn't run has exit code %d, wanted -1", runner.ExitCode("missing"))
+	}
+}
+
+func TestRunCancelStopsQueuedHosts(t *testing.T) {
+	defer func(parallel int) { flagParallel = parallel }(flagParallel)
+	flagParallel = 1
+
+	hosts := []string{"a", "b", "c", "d"}
+	cluster := &fakeCluster{hosts: make(map[string]*fakeHost), started: make(chan string)}
+	for _, host := range hosts {
+		cluster.hosts[host] = &fakeHost{block: true}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cluster.started
+		cancel()
+	}()
+
+	runner := newFakeRunner(cluster)
+	results := runFake(ctx, runner, hosts)
+
+	if len(cluster.connected) != 1 || len(cluster.ran) != 1 {
+		t.Fatalf("Connected to %v and ran on %v after cancelling, wanted just the first host", cluster.connected, cluster.ran)
+	}
+
+	for _, host := range hosts {
+		if results[host].result != context.Canceled {
+			t.Errorf("%s: result %v, wanted %v", host, results[host].result, context.Canceled)
+		}
+
+		if code := runner.ExitCode(host); code != -1 {
+			t.Errorf("%s: exit code %d, wanted -1", host, code)
+		}
+	}
+}
+
+// Runs a command on one fake host through runHost, returning its exit code,
+// error and output
+func runFakeHost(ctx context.Context, runner *Runner, host *fakeHost) (int, error, *IOResult) {
+	cluster := &fakeCluster{hosts: map[string]*fakeHost{"host": host}}
+	runner.dial = newFakeRunner(cluster).dial
+
+	coll := NewCaptureIOCollector()
+	remote := coll.NewRemote("host")
+	code := -1
+	var err error
+	go func() {
+		code, err = runner.runHost(ctx, "host", remote, NewSSHCommand("true", false, false, false, 0, nil))
+		remote.Done(err)
+	}()
+
+	coll.Read()
+	return code, err, coll.Results[0]
+}
+
+func TestRunHost(t *testing.T) {
+	defer func(requireCmds []string) { flagRequireCmds = requireCmds }(flagRequireCmds)
+
+	linux := map[string]string{osProbe: "Linux 4.15.0\n"}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		host        *fakeHost
+		onlyOS      string
+		requireCmds []string
+		ctx         context.Context
+		code        int
+		err         string
+		unreachable bool
+		status      string
+	}{
+		{name: "exit 0", host: &fakeHost{}, code: 0},
+		{name: "exit code", host: &fakeHost{code: 5}, code: 5},
+		{name: "command error", host: &fakeHost{runErr: errors.New("Session closed")}, code: -1, err: "Session closed"},
+		{name: "connect error", host: &fakeHost{connectErr: errors.New("Connection refused")}, code: -1, err: "Connection refused", unreachable: true},
+		{name: "cancelled", host: &fakeHost{}, ctx: cancelled, code: -1, err: context.Canceled.Error()},
+		{name: "os matches", host: &fakeHost{outputs: linux, code: 2}, onlyOS: "^Linux 4\\.", code: 2},
+		{name: "os skipped", host: &fakeHost{outputs: linux}, onlyOS: "^Linux 5\\.", code: exitSkipped, status: "does not match -only-os"},
+		{name: "os probe fails", host: &fakeHost{outputErr: errors.New("EOF")}, onlyOS: ".", code: -1, err: "Failed to detect OS: EOF"},
+		{name: "commands found", host: &fakeHost{missing: []string{"docker"}}, requireCmds: []string{"jq"}, code: 0},
+		{name: "commands missing", host: &fakeHost{missing: []string{"jq", "docker"}}, requireCmds: []string{"jq", "curl", "docker"}, code: exitSkipped, status: "missing: jq, docker"},
+		{name: "commands probe fails", host: &fakeHost{outputErr: errors.New("EOF")}, requireCmds: []string{"jq"}, code: -1, err: "Failed to check -require-cmd: EOF"},
+	}
+
+	for _, test := range tests {
+		runner := newFakeRunner(&fakeCluster{})
+		if test.onlyOS != "" {
+			runner.onlyOS = regexp.MustCompile(test.onlyOS)
+		}
+
+		flagRequireCmds = test.requireCmds
+		ctx := test.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		code, err, result := runFakeHost(ctx, runner, test.host)
+		if code != test.code {
+			t.Errorf("%s: exit code %d, wanted %d", test.name, code, test.code)
+		}
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err.Error())
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: error %v, wanted %s", test.name, err, test.err)
+		}
+
+		if isConnectError(err) != test.unreachable {
+			t.Errorf("%s: connect error is %t, wanted %t", test.name, isConnectError(err), test.unreachable)
+		}
+
+		if failed := hostFailed(code, err); failed != (test.code != 0 && test.code != exitSkipped) {
+			t.Errorf("%s: counted as failed is %t", test.name, failed)
+		}
+
+		if test.status != "" && !strings.Contains(result.stream(-1), test.status) {
+			t.Errorf("%s: status %q doesn't mention %q", test.name, result.stream(-1), test.status)
+		}
+	}
+}
+
+func TestRunSkipsAreNotFailures(t *testing.T) {
+	defer func(requireCmds []string) { flagRequireCmds = requireCmds }(flagRequireCmds)
+	flagRequireCmds = []string{"jq"}
+
+	cluster := &fakeCluster{hosts: map[string]*fakeHost{
+		"ok":      {},
+		"skipped": {missing: []string{"jq"}},
+		"failed":  {code: 1},
+		"down":    {connectErr: errors.New("Connection refused")},
+	}}
+
+	runner := newFakeRunner(cluster)
+	hosts := []string{"ok", "skipped", "failed", "down"}
+	runFake(context.Background(), runner, hosts)
+
+	if ok, failed, skipped := runner.Summary(hosts); ok != 1 || failed != 2 || skipped != 1 {
+		t.Errorf("Summary is %d ok, %d failed, %d skipped, wanted 1, 2, 1", ok, failed, skipped)
+	}
+
+	if code := runner.ExitCode("skipped"); code != exitSkipped {
+		t.Errorf("Skipped host has exit code %d, wanted %d", code, exitSkipped)
+	}
+
+	for host, want := range map[string]bool{"ok": false, "skipped": false, "failed": false, "down": true} {
+		if runner.Unreachable(host) != want {
+			t.Errorf("%s: unreachable is %t, wanted %t", host, runner.Unreachable(host), want)
+		}
+	}
+}
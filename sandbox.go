@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Sizes (in KiB) of every executor directory under the agent work_dir.
+// %s is the quoted work_dir.
+const sandboxScript = `du -sk %s/slaves/*/frameworks/*/executors/* 2>/dev/null; true`
+
+// Disk usage of one executor sandbox
+type sandboxUsage struct {
+	host      string
+	framework string
+	executor  string
+	kb        int64
+}
+
+// Reports the largest framework and executor sandboxes across the hosts
+func sandboxUsageMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("sandbox-usage", flag.ExitOnError)
+	workDir := fs.String("work-dir", "/var/lib/mesos/slave", "Mesos agent work_dir on the remote hosts")
+	top := fs.Int("top", 10, "How many of the largest frameworks and executors to show")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] sandbox-usage <spec> [-work-dir dir] [-top n]", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand(fmt.Sprintf(sandboxScript, shellQuote(*workDir)), flagSudo, flagPty, false, flagTimeout, nil)
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	var usages []*sandboxUsage
+	for _, result := range coll.Results {
+		if result.result != nil {
+			msgs.Printf("Failed on %s: %s", result.host, result.result.Error())
+			continue
+		}
+
+		usages = append(usages, parseSandboxUsage(result.host, result.Stdout())...)
+	}
+
+	printSandboxUsage(os.Stdout, usages, *top)
+	runner.Finish()
+}
+
+// Parses du output of the form "<kb>\t<work_dir>/slaves/<agent>/frameworks/<fw>/executors/<exec>"
+func parseSandboxUsage(host, output string) []*sandboxUsage {
+	var result []*sandboxUsage
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		executor := path.Base(fields[1])
+		framework := path.Base(path.Dir(path.Dir(fields[1])))
+		result = append(result, &sandboxUsage{
+			host:      host,
+			framework: framework,
+			executor:  executor,
+			kb:        kb,
+		})
+	}
+
+	return result
+}
+
+// Prints the largest frameworks (summed across hosts) and executors
+func printSandboxUsage(out io.Writer, usages []*sandboxUsage, top int) {
+	frameworks := make(map[string]int64)
+	frameworkHosts := make(map[string]map[string]bool)
+	for _, usage := range usages {
+		frameworks[usage.framework] += usage.kb
+		if frameworkHosts[usage.framework] == nil {
+			frameworkHosts[usage.framework] = make(map[string]bool)
+		}
+
+		frameworkHosts[usage.framework][usage.host] = true
+	}
+
+	var ids []string
+	for id := range frameworks {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return frameworks[ids[i]] > frameworks[ids[j]] })
+	sort.Slice(usages, func(i, j int) bool { return usages[i].kb > usages[j].kb })
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FRAMEWORK\tSIZE\tHOSTS")
+	for i, id := range ids {
+		if i >= top {
+			break
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\n", id, formatKB(frameworks[id]), len(frameworkHosts[id]))
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "EXECUTOR\tSIZE\tHOST\tFRAMEWORK")
+	for i, usage := range usages {
+		if i >= top {
+			break
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", usage.executor, formatKB(usage.kb), usage.host, usage.framework)
+	}
+
+	w.Flush()
+}
+
+// Formats a size in KiB for humans
+func formatKB(kb int64) string {
+	size := float64(kb)
+	for _, unit := range []string{"K", "M", "G"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f%s", size, unit)
+		}
+
+		size /= 1024
+	}
+
+	return fmt.Sprintf("%.1fT", size)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+// Where schedules and their results are kept, unless told otherwise
+const (
+	defaultScheduleFile = "~/.config/mesos-ssh/schedule.json"
+	defaultRunsDir      = "~/.local/share/mesos-ssh/runs"
+)
+
+// Format of the timestamp that names each result file
+const runFileTime = "20060102T150405Z"
+
+// A mesos-ssh command line to run every so often
+type scheduledJob struct {
+	Name   string   `json:"name"`
+	Every  string   `json:"every"`
+	Args   []string `json:"args"`
+	Keep   int      `json:"keep"`
+	Notify string   `json:"notify,omitempty"`
+}
+
+// What one run of a job did, as kept in the results directory
+type scheduledRun struct {
+	Name     string          `json:"name"`
+	Args     []string        `json:"args"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	ExitCode int             `json:"exit_code"`
+	Output   json.RawMessage `json:"output,omitempty"`
+	Text     string          `json:"text,omitempty"`
+	Stderr   string          `json:"stderr,omitempty"`
+}
+
+// Fields available to the -notify template
+type ScheduleFailure struct {
+	Name     shellWord
+	ExitCode int
+	Result   shellWord
+}
+
+// Manages recurring runs, and runs them as a daemon
+func scheduleMain(args []string, msgs *log.Logger) {
+	usage := fmt.Sprintf("Usage: %s schedule add|list|remove|run|daemon ...", os.Args[0])
+	if len(args) < 1 {
+		msgs.Fatalf("%s", usage)
+	}
+
+	fs := flag.NewFlagSet("schedule "+args[0], flag.ExitOnError)
+	file := fs.String("file", defaultScheduleFile, "File the schedules are kept in")
+	runs := fs.String("runs", defaultRunsDir, "Directory each run's results are kept in")
+
+	switch args[0] {
+	case "add":
+		name := fs.String("name", "", "Name of the job")
+		every := fs.Duration("every", 0, "How often to run the job")
+		keep := fs.Int("keep", 10, "How many runs' results to keep")
+		notify := fs.String("notify", "", "Local command to run when a run fails; {{.Name}}, {{.ExitCode}} and\n\t{{.Result}} (the result file) are substituted")
+		rest := parseSubcommandFlags(fs, args[1:])
+		if *name == "" || *every <= 0 || len(rest) == 0 {
+			msgs.Fatalf("Usage: %s schedule add -name <name> -every <duration> [-keep n] [-notify cmd] -- [OPTIONS] <spec> <command>", os.Args[0])
+		}
+
+		if strings.ContainsAny(*name, `/\`) || *name == "." || *name == ".." {
+			msgs.Fatalf("Invalid job name %s", *name)
+		}
+
+		if _, err := parseNotify(*notify); *notify != "" && err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		job := &scheduledJob{
+			Name:   *name,
+			Every:  every.String(),
+			Args:   rest,
+			Keep:   *keep,
+			Notify: *notify,
+		}
+
+		if err := updateSchedule(expandHome(*file), func(jobs []*scheduledJob) ([]*scheduledJob, error) {
+			for _, existing := range jobs {
+				if existing.Name == job.Name {
+					return nil, fmt.Errorf("There is already a job named %s", job.Name)
+				}
+			}
+
+			return append(jobs, job), nil
+		}); err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+	case "remove":
+		rest := parseSubcommandFlags(fs, args[1:])
+		if len(rest) != 1 {
+			msgs.Fatalf("Usage: %s schedule remove <name>", os.Args[0])
+		}
+
+		if err := updateSchedule(expandHome(*file), func(jobs []*scheduledJob) ([]*scheduledJob, error) {
+			for i, job := range jobs {
+				if job.Name == rest[0] {
+					return append(jobs[:i], jobs[i+1:]...), nil
+				}
+			}
+
+			return nil, fmt.Errorf("There is no job named %s", rest[0])
+		}); err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+	case "list":
+		parseSubcommandFlags(fs, args[1:])
+		jobs, err := readSchedule(expandHome(*file))
+		if err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		printSchedule(jobs, expandHome(*runs))
+
+	case "run":
+		rest := parseSubcommandFlags(fs, args[1:])
+		if len(rest) != 1 {
+			msgs.Fatalf("Usage: %s schedule run <name>", os.Args[0])
+		}
+
+		jobs, err := readSchedule(expandHome(*file))
+		if err != nil {
+			msgs.Fatalf("%s", err.Error())
+		}
+
+		for _, job := range jobs {
+			if job.Name == rest[0] {
+				if code := runJob(job, expandHome(*runs), msgs); code != 0 {
+					os.Exit(1)
+				}
+
+				return
+			}
+		}
+
+		msgs.Fatalf("There is no job named %s", rest[0])
+
+	case "daemon":
+		poll := fs.Duration("poll", 30*time.Second, "How often to check for jobs that are due, and changes to the schedule")
+		parseSubcommandFlags(fs, args[1:])
+		scheduleDaemon(expandHome(*file), expandHome(*runs), *poll, msgs)
+
+	default:
+		msgs.Fatalf("%s", usage)
+	}
+}
+
+// Reads the schedule.  A missing file is an empty schedule.
+func readSchedule(path string) ([]*scheduledJob, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var jobs []*scheduledJob
+	if err := json.Unmarshal(contents, &jobs); err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", path, err.Error())
+	}
+
+	return jobs, nil
+}
+
+// Changes the schedule with update, replacing the file
+func updateSchedule(path string, update func([]*scheduledJob) ([]*scheduledJob, error)) error {
+	jobs, err := readSchedule(path)
+	if err != nil {
+		return err
+	}
+
+	if jobs, err = update(jobs); err != nil {
+		return err
+	}
+
+	contents, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Replace the file in one go, so the daemon never sees half of it
+	staged := path + ".new"
+	if err := ioutil.WriteFile(staged, append(contents, '\n'), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(staged, path)
+}
+
+// Parses a -notify template, whose fields are quoted like -on-failure-exec's
+func parseNotify(notify string) (*template.Template, error) {
+	tmpl, err := parseShellTemplate("notify", notify)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -notify: %s", err.Error())
+	}
+
+	return tmpl, nil
+}
+
+// Lists the result files for a job, oldest first
+func jobRuns(runs string, name string) []string {
+	files, _ := filepath.Glob(filepath.Join(runs, name, "*.json"))
+	sort.Strings(files)
+	return files
+}
+
+// Reads the last run of a job, if there has been one
+func lastRun(runs string, name string) *scheduledRun {
+	files := jobRuns(runs, name)
+	if len(files) == 0 {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil
+	}
+
+	run := &scheduledRun{}
+	if err := json.Unmarshal(contents, run); err != nil {
+		return nil
+	}
+
+	return run
+}
+
+// Prints each job with its last run
+func printSchedule(jobs []*scheduledJob, runs string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tEVERY\tLAST RUN\tEXIT\tCOMMAND")
+	for _, job := range jobs {
+		last, exit := "never", "-"
+		if run := lastRun(runs, job.Name); run != nil {
+			last = run.Started.Local().Format(time.RFC3339)
+			exit = fmt.Sprintf("%d", run.ExitCode)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.Name, job.Every, last, exit, strings.Join(job.Args, " "))
+	}
+
+	w.Flush()
+}
+
+// Runs due jobs until killed, rereading the schedule every poll
+func scheduleDaemon(file, runs string, poll time.Duration, msgs *log.Logger) {
+	var lock sync.Mutex
+	running := make(map[string]bool)
+
+	// When each job was last started here, in case its result wasn't saved
+	started := make(map[string]time.Time)
+
+	msgs.Printf("Running jobs from %s", file)
+	for {
+		jobs, err := readSchedule(file)
+		if err != nil {
+			msgs.Printf("%s", err.Error())
+		}
+
+		for _, job := range jobs {
+			every, err := time.ParseDuration(job.Every)
+			if err != nil || every <= 0 {
+				msgs.Printf("Job %s has a bad interval %s", job.Name, job.Every)
+				continue
+			}
+
+			last := started[job.Name]
+			if run := lastRun(runs, job.Name); run != nil && run.Started.After(last) {
+				last = run.Started
+			}
+
+			if time.Since(last) < every {
+				continue
+			}
+
+			// Don't start a job again while it's still running
+			lock.Lock()
+			if running[job.Name] {
+				lock.Unlock()
+				continue
+			}
+
+			running[job.Name] = true
+			started[job.Name] = time.Now()
+			lock.Unlock()
+
+			go func(job *scheduledJob) {
+				runJob(job, runs, msgs)
+
+				lock.Lock()
+				delete(running, job.Name)
+				lock.Unlock()
+			}(job)
+		}
+
+		time.Sleep(poll)
+	}
+}
+
+// Runs a job once, keeping its result and notifying if it failed.  Returns
+// the run's exit code.
+func runJob(job *scheduledJob, runs string, msgs *log.Logger) int {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(self, append([]string{"-output", "json"}, job.Args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	msgs.Printf("Running job %s", job.Name)
+	run := &scheduledRun{
+		Name:    job.Name,
+		Args:    job.Args,
+		Started: time.Now().UTC(),
+	}
+
+	run.ExitCode = -1
+	if err := cmd.Run(); err == nil {
+		run.ExitCode = 0
+	} else if exit, ok := err.(*exec.ExitError); ok {
+		run.ExitCode = exit.ExitCode()
+	} else {
+		stderr.WriteString(err.Error())
+	}
+
+	run.Finished = time.Now().UTC()
+	run.Stderr = stderr.String()
+	if json.Valid(stdout.Bytes()) {
+		run.Output = json.RawMessage(stdout.Bytes())
+	} else {
+		run.Text = stdout.String()
+	}
+
+	result, err := saveRun(job, runs, run)
+	if err != nil {
+		msgs.Printf("Failed to save the result of job %s: %s", job.Name, err.Error())
+	}
+
+	msgs.Printf("Job %s finished with %d", job.Name, run.ExitCode)
+	if run.ExitCode != 0 && job.Notify != "" {
+		notifyFailure(job, run.ExitCode, result, msgs)
+	}
+
+	return run.ExitCode
+}
+
+// Writes the result of a run, and removes results beyond those the job
+// keeps.  Returns the path of the result.
+func saveRun(job *scheduledJob, runs string, run *scheduledRun) (string, error) {
+	dir := filepath.Join(runs, job.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	contents, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, run.Started.Format(runFileTime)+".json")
+	if err := ioutil.WriteFile(path, append(contents, '\n'), 0600); err != nil {
+		return "", err
+	}
+
+	if files := jobRuns(runs, job.Name); job.Keep > 0 && len(files) > job.Keep {
+		for _, old := range files[:len(files)-job.Keep] {
+			os.Remove(old)
+		}
+	}
+
+	return path, nil
+}
+
+// Runs a job's -notify command for a failed run
+func notifyFailure(job *scheduledJob, code int, result string, msgs *log.Logger) {
+	tmpl, err := parseNotify(job.Notify)
+	if err != nil {
+		msgs.Printf("Job %s: %s", job.Name, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &ScheduleFailure{Name: shellWord(job.Name), ExitCode: code, Result: shellWord(result)}); err != nil {
+		msgs.Printf("Failed to expand -notify for job %s: %s", job.Name, err.Error())
+		return
+	}
+
+	cmd := shellCommand(buf.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		msgs.Printf("Notification for job %s failed: %s", job.Name, err.Error())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Gets the script for -script into dir, downloading it if it is a URL, and
+// checks it against expectedSHA256 if that is set.  Returns the path of the
+// copy, which is executable so that it runs with its own interpreter.
+func fetchScript(location, expectedSHA256, dir string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		// Local file
+		f, err := os.Open(location)
+		if err != nil {
+			return "", err
+		}
+
+		defer f.Close()
+		return stageScript(f, filepath.Join(dir, filepath.Base(location)), expectedSHA256)
+	}
+
+	if u.Scheme == "http" && expectedSHA256 == "" {
+		return "", fmt.Errorf("Refusing to run a script fetched over plain http without -script-sha256")
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("Cannot determine a file name for %s", location)
+	}
+
+	resp, err := http.Get(location)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to fetch %s: %s", location, resp.Status)
+	}
+
+	return stageScript(resp.Body, filepath.Join(dir, name), expectedSHA256)
+}
+
+// Copies a script to local, makes it executable whatever the umask, and
+// checks it against expectedSHA256 if that is set
+func stageScript(src io.Reader, local, expectedSHA256 string) (string, error) {
+	file, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, src)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Chmod(local, 0755); err != nil {
+		return "", err
+	}
+
+	return local, verifySHA256(local, expectedSHA256)
+}
+
+// Checks a file's SHA-256 checksum.  Always succeeds if expected is empty.
+func verifySHA256(file, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != strings.ToLower(expected) {
+		return fmt.Errorf("Checksum mismatch for %s: got %s, expected %s", file, actual, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Packet types, flags and status codes from version 3 of the SFTP protocol
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpWrite    = 6
+	sftpFSetStat = 10
+	sftpStatus   = 101
+	sftpHandle   = 102
+
+	sftpOpenWrite    = 0x02
+	sftpOpenCreate   = 0x08
+	sftpOpenTruncate = 0x10
+
+	sftpAttrPermissions = 0x04
+
+	sftpStatusOK = 0
+)
+
+const (
+	// Largest write sent in one packet; every server accepts at least this
+	sftpChunk = 32768
+
+	// Writes sent before waiting for the first of them to be acknowledged
+	sftpWindow = 16
+
+	// Largest response accepted from the server
+	sftpMaxPacket = 256 * 1024
+)
+
+// Returned by newSFTPClient when the host doesn't offer the sftp subsystem
+var errNoSFTP = errors.New("SFTP is not available")
+
+// A failure reported by the SFTP server, such as for a missing directory or
+// a full disk
+type sftpError struct {
+	code    uint32
+	message string
+}
+
+func (err *sftpError) Error() string {
+	if err.message == "" {
+		return fmt.Sprintf("SFTP error %d", err.code)
+	}
+
+	return err.message
+}
+
+// Minimal SFTP client, enough to write files into a directory.  Not safe for
+// concurrent use, and unusable after any error.
+type sftpClient struct {
+	session *ssh.Session
+	in      io.WriteCloser
+	out     io.Reader
+	nextID  uint32
+}
+
+// Starts the sftp subsystem on a new session of conn
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	out, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, errNoSFTP
+	}
+
+	client := &sftpClient{session: session, in: in, out: out}
+	if err := client.send(appendUint32([]byte{sftpInit}, 3)); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if typ, _, err := client.recv(); err != nil || typ != sftpVersion {
+		client.Close()
+		return nil, errNoSFTP
+	}
+
+	return client, nil
+}
+
+// Ends the SFTP session
+func (client *sftpClient) Close() error {
+	client.in.Close()
+	return client.session.Close()
+}
+
+// Writes everything from src to remotePath, replacing anything already there
+// and giving it mode
+func (client *sftpClient) Put(src io.Reader, remotePath string, mode os.FileMode) error {
+	perm := uint32(mode.Perm())
+	open := appendString(nil, remotePath)
+	open = appendUint32(open, sftpOpenWrite|sftpOpenCreate|sftpOpenTruncate)
+	open = appendUint32(appendUint32(open, sftpAttrPermissions), perm)
+	if err := client.request(sftpOpen, open); err != nil {
+		return err
+	}
+
+	handle, err := client.recvHandle()
+	if err != nil {
+		return err
+	}
+
+	// Keep a window of writes in flight, rather than waiting out a round
+	// trip for each chunk
+	buf := make([]byte, sftpChunk)
+	var offset uint64
+	pending := 0
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			write := appendUint64(appendString(nil, handle), offset)
+			write = appendString(write, string(buf[:n]))
+			if err := client.request(sftpWrite, write); err != nil {
+				return err
+			}
+
+			offset += uint64(n)
+			if pending++; pending == sftpWindow {
+				if err := client.recvStatus(); err != nil {
+					return err
+				}
+
+				pending--
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+
+	for ; pending > 0; pending-- {
+		if err := client.recvStatus(); err != nil {
+			return err
+		}
+	}
+
+	// The server applies its umask when creating the file, so set the mode
+	// again
+	setstat := appendUint32(appendString(nil, handle), sftpAttrPermissions)
+	if err := client.request(sftpFSetStat, appendUint32(setstat, perm)); err != nil {
+		return err
+	}
+
+	if err := client.recvStatus(); err != nil {
+		return err
+	}
+
+	if err := client.request(sftpClose, appendString(nil, handle)); err != nil {
+		return err
+	}
+
+	return client.recvStatus()
+}
+
+// Sends a request of the specified type, with a new ID ahead of body
+func (client *sftpClient) request(typ byte, body []byte) error {
+	client.nextID++
+	packet := appendUint32([]byte{typ}, client.nextID)
+	return client.send(append(packet, body...))
+}
+
+// Sends a packet, prefixed with its length
+func (client *sftpClient) send(packet []byte) error {
+	_, err := client.in.Write(append(appendUint32(nil, uint32(len(packet))), packet...))
+	return err
+}
+
+// Reads a packet and returns its type and the rest of its contents
+func (client *sftpClient) recv() (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(client.out, length[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size < 1 || size > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("Bad SFTP packet length %d", size)
+	}
+
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(client.out, packet); err != nil {
+		return 0, nil, err
+	}
+
+	return packet[0], packet[1:], nil
+}
+
+// Reads a response that should be a status, and returns its error if it
+// isn't OK
+func (client *sftpClient) recvStatus() error {
+	typ, data, err := client.recv()
+	if err != nil {
+		return err
+	} else if typ != sftpStatus {
+		return fmt.Errorf("Unexpected SFTP response type %d", typ)
+	}
+
+	return parseSFTPStatus(data)
+}
+
+// Reads a response that should be a handle
+func (client *sftpClient) recvHandle() (string, error) {
+	typ, data, err := client.recv()
+	if err != nil {
+		return "", err
+	} else if typ == sftpStatus {
+		if err := parseSFTPStatus(data); err != nil {
+			return "", err
+		}
+
+		return "", fmt.Errorf("SFTP server sent no handle")
+	} else if typ != sftpHandle || len(data) < 4 {
+		return "", fmt.Errorf("Unexpected SFTP response type %d", typ)
+	}
+
+	handle, _, ok := readSFTPString(data[4:])
+	if !ok {
+		return "", fmt.Errorf("Truncated SFTP handle")
+	}
+
+	return handle, nil
+}
+
+// Parses the body of a status response (after its type), giving nil if the
+// status is OK
+func parseSFTPStatus(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("Truncated SFTP status")
+	}
+
+	code := binary.BigEndian.Uint32(data[4:8])
+	if code == sftpStatusOK {
+		return nil
+	}
+
+	// Servers for older versions of the protocol may leave out the message
+	message, _, _ := readSFTPString(data[8:])
+	return &sftpError{code, message}
+}
+
+// Reads a length-prefixed string, returning it and what follows it
+func readSFTPString(data []byte) (string, []byte, bool) {
+	if len(data) < 4 {
+		return "", data, false
+	}
+
+	size := binary.BigEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4) {
+		return "", data, false
+	}
+
+	return string(data[4 : 4+size]), data[4+size:], true
+}
+
+func appendUint32(buf []byte, n uint32) []byte {
+	return append(buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendUint64(buf []byte, n uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(n>>32)), uint32(n))
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUint32(buf, uint32(len(s))), s...)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Characters that never need quoting in a POSIX shell word
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./-_"
+
+// Quotes s so that a POSIX shell reads it back as a single literal word.
+// Strings made up entirely of safe characters are returned unchanged.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, c := range s {
+		if !strings.ContainsRune(shellSafe, c) {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// Creates a command that runs a command line with the local shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+
+	return exec.Command("/bin/sh", "-c", command)
+}
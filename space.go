@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Checks that the filesystem holding dir on the remote host has room for
+// files, plus margin bytes, before any of them are sent.  Hosts without a
+// usable df are let through, since the transfer will fail by itself if it
+// must.
+func (sesh *SSHSession) checkSpace(dir string, files []string, margin int64) error {
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+	}
+
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+	output, err := session.Output("df -Pk " + shellQuote(dir))
+	if err != nil {
+		log.Printf("Failed to check free space on %s: %s", sesh.Host, err.Error())
+		return nil
+	}
+
+	available, err := parseDfAvailable(string(output))
+	if err != nil {
+		log.Printf("Failed to check free space on %s: %s", sesh.Host, err.Error())
+		return nil
+	}
+
+	if needed := size + margin; available*1024 < needed {
+		return fmt.Errorf("Not enough space for -f files in %s (%s free, %s needed), so the host was skipped", dir, formatKB(available), formatKB((needed+1023)/1024))
+	}
+
+	return nil
+}
+
+// Gets the KiB available from the output of df -Pk for one path
+func parseDfAvailable(output string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(strings.Replace(output, "\r", "", -1)), "\n")
+
+	// The fields are filesystem, size, used, available, capacity and mount
+	// point; the filesystem's name may have spaces in it, so count from the
+	// end
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 6 {
+		return 0, fmt.Errorf("Unexpected df output %q", lines[len(lines)-1])
+	}
+
+	return strconv.ParseInt(fields[len(fields)-3], 10, 64)
+}
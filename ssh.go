@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -9,8 +10,10 @@ import (
 	"log"
 	"net"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,17 +24,61 @@ type SSHCommand struct {
 	Pty          bool
 	Timeout      time.Duration
 	Files        []string
+	Fetch        []*FetchURL
 	ForwardAgent bool
+
+	// With Sudo, the user to run the command as, if not root
+	SudoUser string
+
+	// Hides noise that sudo prints before its password prompt
+	Noise *NoiseFilter
+
+	// Canned responses to prompts the command shows
+	Answers []*Answer
+
+	// Files at least this big are sent so that an interrupted transfer can
+	// be resumed (0 sends all files at once)
+	ResumeAbove int64
+
+	// If set, the files' checksums are compared with the local ones once
+	// they have been sent
+	VerifyFiles bool
+
+	// Room to leave free on the remote filesystem after sending the files;
+	// hosts without it are skipped (negative sends without checking)
+	SpaceMargin int64
+
+	// Remote glob patterns of files to copy back into CollectDir/<host>
+	// after the command exits
+	Collect    []string
+	CollectDir string
+
+	// If set, the command runs in a transient systemd unit of this name,
+	// with these properties
+	Unit           string
+	UnitProperties []string
 }
 
-// A single SSH connection to a remote host
+// A single SSH connection to a remote host.  Implements Transport.
 type SSHSession struct {
-	Host   string
+	Host string
+	Port int
+
+	// Where to connect to, if not Host
+	Address string
+
 	Config *ssh.ClientConfig
 	Remote *RemoteIO
 
 	connection *ssh.Client
 	auth       *Auth
+	hostKeys   *HostKeyReport
+
+	// Opens the network connection, e.g. through a jump host
+	dial DialFunc
+
+	// Paces file uploads, with -bwlimit
+	limiter *rateLimiter
 }
 
 // Creates an SSHCommand
@@ -43,85 +90,219 @@ func NewSSHCommand(cmd string, sudo, pty, forwardAgent bool, timeout time.Durati
 		Timeout:      timeout,
 		Files:        files,
 		ForwardAgent: forwardAgent,
+		Noise:        sudoNoise,
 	}
 }
 
-// Creates an (unconnected) SSH client
-func NewSSHSession(host, user string, auth *Auth, remote *RemoteIO) *SSHSession {
+// Creates an (unconnected) SSH client.  Host keys are checked with verify, and
+// host keys and server banners are recorded in hostKeys, if it is non-nil.
+// The network connection is opened with dial, or directly if it is nil.
+func NewSSHSession(host, user string, port int, auth *Auth, remote *RemoteIO, verify ssh.HostKeyCallback, hostKeys *HostKeyReport, dial DialFunc) *SSHSession {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
 	return &SSHSession{
-		Host:   host,
-		Remote: remote,
-		auth:   auth,
+		dial:     dial,
+		Host:     host,
+		Port:     port,
+		Remote:   remote,
+		auth:     auth,
+		hostKeys: hostKeys,
 		Config: &ssh.ClientConfig{
 			User: user,
 			Auth: auth.getAuthMethods(),
 			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-				return nil
+				if hostKeys != nil {
+					hostKeys.recordKey(host, key)
+				}
+
+				return verify(hostname, remote, key)
 			},
 		},
 	}
 }
 
-// Initiates the connection for this client
-func (sesh *SSHSession) Connect(port int) error {
+// Initiates the connection for this client.  Cancelling ctx aborts the dial.
+func (sesh *SSHSession) Connect(ctx context.Context) error {
 	log.Printf("Starting connection to %s", sesh.Host)
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", sesh.Host, port), sesh.Config)
+	address := sesh.Host
+	if sesh.Address != "" {
+		address = sesh.Address
+	}
+
+	addr := net.JoinHostPort(address, strconv.Itoa(sesh.Port))
+	conn, err := sesh.dial(ctx, "tcp", addr)
 	if err != nil {
 		return err
 	}
 
+	// Log in with this connection's own auth methods, so that it can report
+	// whether a prompted password worked
+	config := *sesh.Config
+	var loggedIn func(error)
+	config.Auth, loggedIn = sesh.auth.loginMethods()
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &config)
+	loggedIn(err)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	connection := ssh.NewClient(c, chans, reqs)
+
+	if sesh.hostKeys != nil {
+		sesh.hostKeys.recordBanner(sesh.Host, string(connection.ServerVersion()))
+	}
+
 	sesh.connection = connection
 	return nil
 }
 
 // Closes this ssh session
-func (sesh *SSHSession) Close() {
-	sesh.connection.Close()
+func (sesh *SSHSession) Close() error {
+	err := sesh.connection.Close()
 	sesh.connection = nil
+	return err
+}
+
+// Closes the connection if ctx is cancelled before the returned function is
+// called.
+func (sesh *SSHSession) watch(ctx context.Context) func() {
+	connection := sesh.connection
+	stop := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.Close()
+		case <-stop:
+		}
+	}()
+
+	return func() { close(stop) }
+}
+
+// Sends the specified files to the specified directory.  Cancelling ctx
+// closes the connection.
+func (sesh *SSHSession) PutFiles(ctx context.Context, dir string, files []string) error {
+	defer sesh.watch(ctx)()
+	err := sesh.sendFiles(dir, files)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// Runs the specified SSHCommand and returns its exit code.  Cancelling ctx
+// closes the connection.
+func (sesh *SSHSession) RunCommand(ctx context.Context, cmd *SSHCommand) (int, error) {
+	defer sesh.watch(ctx)()
+	code, err := sesh.run(cmd)
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+
+	return code, err
+}
+
+// Runs a short command and returns its stdout.  Cancelling ctx closes the
+// connection.
+func (sesh *SSHSession) Output(ctx context.Context, command string) (string, error) {
+	defer sesh.watch(ctx)()
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	defer session.Close()
+	output, err := session.Output(command)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	return string(output), err
 }
 
-// Runs the specified SSHCommand
-func (sesh *SSHSession) Run(cmd *SSHCommand) error {
-	if len(cmd.Files) > 0 {
-		tmpdir, err := sesh.mktemp()
+// Sends files, if any, runs the command and collects the files it produced
+func (sesh *SSHSession) run(cmd *SSHCommand) (int, error) {
+	var tmpdir string
+	if len(cmd.Files) > 0 || len(cmd.Fetch) > 0 {
+		var err error
+		tmpdir, err = sesh.mktemp()
 		if err != nil {
-			return err
+			return -1, err
 		}
 
 		defer sesh.deltemp(tmpdir)
-		if err := sesh.sendFiles(tmpdir, cmd.Files); err != nil {
-			return err
+		if len(cmd.Files) > 0 && cmd.SpaceMargin >= 0 {
+			if err := sesh.checkSpace(tmpdir, cmd.Files, cmd.SpaceMargin); err != nil {
+				return -1, err
+			}
 		}
 
-		return sesh.runCommand(cmd, tmpdir)
-	} else {
-		return sesh.runCommand(cmd, "")
+		if err := sesh.sendAll(tmpdir, cmd.Files, cmd.ResumeAbove); err != nil {
+			return -1, err
+		}
+
+		if cmd.VerifyFiles {
+			if err := sesh.verifyFiles(tmpdir, cmd.Files); err != nil {
+				return -1, err
+			}
+		}
+
+		if len(cmd.Fetch) > 0 {
+			if err := sesh.fetchURLs(tmpdir, cmd.Fetch); err != nil {
+				return -1, err
+			}
+		}
+
+		// mktemp makes the directory private to the login user
+		if cmd.Sudo && cmd.SudoUser != "" {
+			if err := sesh.opentemp(tmpdir); err != nil {
+				return -1, fmt.Errorf("Failed to open up temporary directory for %s: %s", cmd.SudoUser, err.Error())
+			}
+		}
+	}
+
+	code, err := sesh.runCommand(cmd, tmpdir)
+	if err != nil || len(cmd.Collect) == 0 {
+		return code, err
 	}
+
+	localDir := filepath.Join(cmd.CollectDir, sesh.Host)
+	count, size, err := sesh.collect(tmpdir, cmd.Collect, localDir)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to collect files: %s", err.Error())
+	}
+
+	sesh.Remote.Status(fmt.Sprintf("Collected %d files (%s) into %s\n", count, formatKB(size/1024), localDir))
+	return code, nil
 }
 
 // Runs the actual shell command from the specified directory
-func (sesh *SSHSession) runCommand(cmd *SSHCommand, dir string) error {
+func (sesh *SSHSession) runCommand(cmd *SSHCommand, dir string) (int, error) {
 	if cmd.ForwardAgent {
 		if err := sesh.auth.forwardAgent(sesh.connection); err != nil {
-			return err
+			return -1, err
 		}
 	}
 
 	log.Printf("Initiating session on %s", sesh.Host)
 	session, err := sesh.connection.NewSession()
 	if err != nil {
-		return err
+		return -1, &connectError{err}
 	}
 
 	defer session.Close()
 
 	if cmd.ForwardAgent {
 		if err := agent.RequestAgentForwarding(session); err != nil {
-			return err
+			return -1, err
 		}
 	}
 
-	if cmd.Sudo || cmd.Pty {
+	if cmd.Sudo || cmd.Pty || len(cmd.Answers) > 0 {
 		tmodes := ssh.TerminalModes{
 			ssh.ECHO:          0,
 			ssh.TTY_OP_ISPEED: 14400,
@@ -130,18 +311,18 @@ func (sesh *SSHSession) runCommand(cmd *SSHCommand, dir string) error {
 
 		log.Printf("Requesting pty on %s", sesh.Host)
 		if err := session.RequestPty("xterm", 80, 25, tmodes); err != nil {
-			return err
+			return -1, err
 		}
 	}
 
 	stdout, err := session.StdoutPipe()
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	stderr, err := session.StderrPipe()
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	timeout := time.AfterFunc(cmd.Timeout, func() {
@@ -150,24 +331,59 @@ func (sesh *SSHSession) runCommand(cmd *SSHCommand, dir string) error {
 
 	shcmd := cmd.Command
 	if dir != "" {
-		shcmd = fmt.Sprintf("cd %s; %s", dir, shcmd)
+		shcmd = fmt.Sprintf("cd %s; %s", shellQuote(dir), shcmd)
+	}
+
+	if cmd.Unit != "" {
+		shcmd = systemdRun(cmd.Unit, cmd.UnitProperties, shcmd)
 	}
 
+	// All output must be sent to sesh.Remote before this returns, so
+	// track the goroutines copying it.
+	var copiers sync.WaitGroup
+	copiers.Add(2)
+	go func() {
+		defer copiers.Done()
+		io.Copy(&stderrWriter{sesh.Remote}, stderr)
+	}()
+
 	var cmdErr error
 	if cmd.Sudo {
 		stdin, err := session.StdinPipe()
 		if err != nil {
-			return err
+			return -1, err
+		}
+
+		go func() {
+			defer copiers.Done()
+			sesh.writePass(stdin, stdout, cmd.Noise, cmd.Answers)
+		}()
+
+		sudo := "/usr/bin/sudo"
+		if cmd.SudoUser != "" {
+			sudo += " -u " + shellQuote(cmd.SudoUser)
+		}
+
+		log.Printf("Invoking cmd on %s", sesh.Host)
+		cmdErr = session.Run(sudo + " /bin/bash -c " + shellQuote(shcmd))
+	} else if len(cmd.Answers) > 0 {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return -1, err
 		}
 
-		go sesh.writePass(stdin, stdout)
-		go io.Copy(&stderrWriter{sesh.Remote}, stderr)
+		go func() {
+			defer copiers.Done()
+			sesh.answerPrompts(stdin, stdout, cmd.Answers)
+		}()
 
 		log.Printf("Invoking cmd on %s", sesh.Host)
-		cmdErr = session.Run(fmt.Sprintf("/usr/bin/sudo /bin/bash -c '%s'", shcmd))
+		cmdErr = session.Run(shcmd)
 	} else {
-		go io.Copy(&stdoutWriter{sesh.Remote}, stdout)
-		go io.Copy(&stderrWriter{sesh.Remote}, stderr)
+		go func() {
+			defer copiers.Done()
+			io.Copy(&stdoutWriter{sesh.Remote}, stdout)
+		}()
 
 		log.Printf("Invoking cmd on %s", sesh.Host)
 		cmdErr = session.Run(shcmd)
@@ -175,40 +391,69 @@ func (sesh *SSHSession) runCommand(cmd *SSHCommand, dir string) error {
 
 	timeout.Stop()
 
+	// Closing the session lets the copiers drain what was received and stop,
+	// even if something on the remote end still holds the streams open.
+	session.Close()
+	copiers.Wait()
+
 	if cmdErr == nil {
 		// Exited normally.
 		log.Printf("Cmd on %s terminated normally", sesh.Host)
 		sesh.Remote.Exit(0)
-		return nil
+		return 0, nil
 	} else if exitError, ok := cmdErr.(*ssh.ExitError); ok {
 		// Exited with error status.
-		log.Printf("Cmd on %s terminated with code %d", exitError.ExitStatus())
+		log.Printf("Cmd on %s terminated with code %d", sesh.Host, exitError.ExitStatus())
 		sesh.Remote.Exit(exitError.ExitStatus())
-		return nil
+		return exitError.ExitStatus(), nil
 	} else {
 		// Abnormally exited.
 		log.Printf("Cmd on %s terminated abnormally: %s", sesh.Host, cmdErr.Error())
-		return cmdErr
+		return -1, cmdErr
 	}
 }
 
 // Waits for sudo password prompt, then writes the password, while forwarding
-// all stdout to the specified io.Reader.
-func (sesh *SSHSession) writePass(stdin io.WriteCloser, stdout io.Reader) {
-	var buf bytes.Buffer
+// all stdout to the specified io.Reader.  Lines before the prompt that match
+// noise are removed.  Afterwards, any other prompts are answered.
+func (sesh *SSHSession) writePass(stdin io.WriteCloser, stdout io.Reader, noise *NoiseFilter, answers []*Answer) {
+	var scanned, held, blank bytes.Buffer
 	sect := make([]byte, 32)
 
+	// Sends anything held back
+	release := func() {
+		blank.Write(held.Bytes())
+		if blank.Len() > 0 {
+			sesh.Remote.Stdout(blank.Bytes())
+		}
+
+		blank.Reset()
+		held.Reset()
+	}
+
 	for {
 		n, err := stdout.Read(sect)
+		scanned.Write(sect[:n])
+		if noise == nil {
+			sesh.Remote.Stdout(sect[:n])
+		} else {
+			held.Write(sect[:n])
+			sesh.passLines(&held, &blank, noise)
+		}
+
 		if err != nil {
 			log.Printf("Read error while waiting for password on %s: %s", sesh.Host, err.Error())
+			release()
 			return
 		}
 
-		buf.Write(sect[:n])
-		sesh.Remote.Stdout(sect[:n])
-		if bytes.Contains(buf.Bytes(), []byte("[sudo] password for ")) {
+		if bytes.Contains(scanned.Bytes(), []byte("[sudo] password for ")) {
 			log.Printf("Responding to password prompt on %s", sesh.Host)
+
+			// The prompt and anything held back with it are noise
+			held.Reset()
+			blank.Reset()
+
 			pw, err := sesh.auth.getPassword()
 			if err != nil {
 				// Welp...
@@ -217,10 +462,27 @@ func (sesh *SSHSession) writePass(stdin io.WriteCloser, stdout io.Reader) {
 
 			stdin.Write([]byte(pw))
 			stdin.Write([]byte{'\r'})
+
+			// What sudo prints next says whether it took the password
+			stdout = &sudoWatcher{reader: stdout, auth: sesh.auth}
+
+			if noise != nil {
+				// Nor is the newline sudo prints after the password
+				n, err := stdout.Read(sect)
+				rest := bytes.TrimPrefix(bytes.TrimPrefix(sect[:n], []byte("\r")), []byte("\n"))
+				if len(rest) > 0 {
+					sesh.Remote.Stdout(rest)
+				}
+
+				if err != nil {
+					stdin.Close()
+					return
+				}
+			}
 			break
 		}
 
-		if buf.Len() > 256 {
+		if scanned.Len() > 256 {
 			// Should be early, but sudo might print out warning messages, e.g. if DNS resolution
 			// is funky on the box.  But if it goes too far out, then don't bother.
 			log.Println("No sudo prompt found in first 256 bytes, skipping.")
@@ -228,8 +490,61 @@ func (sesh *SSHSession) writePass(stdin io.WriteCloser, stdout io.Reader) {
 		}
 	}
 
-	stdin.Close()
-	io.Copy(&stdoutWriter{sesh.Remote}, stdout)
+	release()
+	sesh.answerPrompts(stdin, stdout, answers)
+}
+
+// Watches the output after the sudo password for sudo asking again, and
+// tells auth whether the password was accepted
+type sudoWatcher struct {
+	reader  io.Reader
+	auth    *Auth
+	seen    bytes.Buffer
+	decided bool
+}
+
+// How much output after the password may show sudo rejecting it
+const sudoVerdictWindow = 256
+
+func (watch *sudoWatcher) Read(p []byte) (int, error) {
+	n, err := watch.reader.Read(p)
+	if watch.decided {
+		return n, err
+	}
+
+	watch.seen.Write(p[:n])
+	seen := watch.seen.Bytes()
+	if bytes.Contains(seen, []byte("Sorry, try again")) || bytes.Contains(seen, []byte("[sudo] password for ")) || bytes.Contains(seen, []byte("incorrect password")) {
+		watch.decided = true
+		watch.auth.sudoResult(false)
+	} else if err != nil || len(seen) > sudoVerdictWindow {
+		watch.decided = true
+		watch.auth.sudoResult(true)
+	}
+
+	return n, err
+}
+
+// Forwards the whole lines in held that aren't noise.  Blank lines are held
+// back in blank until it's clear whether they are part of the noise.
+func (sesh *SSHSession) passLines(held, blank *bytes.Buffer, noise *NoiseFilter) {
+	for {
+		nl := bytes.IndexByte(held.Bytes(), '\n')
+		if nl < 0 {
+			return
+		}
+
+		line := held.Next(nl + 1)
+		if len(bytes.TrimSpace(line)) == 0 {
+			blank.Write(line)
+		} else if noise.Matches(line) {
+			blank.Reset()
+		} else {
+			blank.Write(line)
+			sesh.Remote.Stdout(blank.Bytes())
+			blank.Reset()
+		}
+	}
 }
 
 // Creates a temporary directory on the remote host.
@@ -242,7 +557,9 @@ func (sesh *SSHSession) mktemp() (string, error) {
 
 	defer session.Close()
 
-	result, err := session.CombinedOutput("mktemp -d")
+	// Name it after the run and operator, so stray directories can be traced
+	template := fmt.Sprintf("mesos-ssh-%s-%s-XXXXXX", runID, operatorName())
+	result, err := session.CombinedOutput(fmt.Sprintf(`mktemp -d "${TMPDIR:-/tmp}"/%s`, template))
 	if err != nil {
 		return "", err
 	}
@@ -250,6 +567,18 @@ func (sesh *SSHSession) mktemp() (string, error) {
 	return strings.TrimRight(string(result), "\r\n"), nil
 }
 
+// Lets other users, such as the -sudo-user, read a temporary directory on
+// the remote host.
+func (sesh *SSHSession) opentemp(dir string) error {
+	session, err := sesh.connection.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+	return session.Run("chmod 755 " + shellQuote(dir))
+}
+
 // Deletes a directory from the remote host.
 func (sesh *SSHSession) deltemp(dir string) error {
 	log.Printf("Removing temporary directory on %s", sesh.Host)
@@ -259,13 +588,86 @@ func (sesh *SSHSession) deltemp(dir string) error {
 	}
 
 	defer session.Close()
-	return session.Run("rm -rf " + dir)
+	return session.Run("rm -rf " + shellQuote(dir))
+}
+
+// Sends the files to the directory on the remote host, with files of at least
+// resumeAbove bytes (if non-zero) sent resumably and the rest with sendFiles
+func (sesh *SSHSession) sendAll(dir string, files []string, resumeAbove int64) error {
+	var small []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		if resumeAbove > 0 && info.Size() >= resumeAbove {
+			if err := sesh.sendResumable(dir, file); err != nil {
+				return err
+			}
+		} else {
+			small = append(small, file)
+		}
+	}
+
+	if len(small) == 0 {
+		return nil
+	}
+
+	return sesh.sendFiles(dir, small)
 }
 
 // Sends the specified files to the specified directory on the remote host
-// via scp,  preserving file modes.
+// via SFTP, or scp if the host doesn't offer SFTP, preserving file modes.
 func (sesh *SSHSession) sendFiles(dir string, files []string) error {
 	log.Printf("Preparing to send files to %s", sesh.Host)
+	client, err := newSFTPClient(sesh.connection)
+	if err == errNoSFTP {
+		log.Printf("SFTP is not available on %s, sending files with scp", sesh.Host)
+		return sesh.scpFiles(dir, files)
+	} else if err != nil {
+		return err
+	}
+
+	defer client.Close()
+	for _, file := range files {
+		log.Printf("Sending %s to %s", file, sesh.Host)
+		if err := sesh.putFile(client, dir, file); err != nil {
+			return fmt.Errorf("Failed to send %s: %s", filepath.Base(file), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Sends one file over SFTP
+func (sesh *SSHSession) putFile(client *sftpClient, dir, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(file)
+	return client.Put(sesh.uploadReader(f, name, info.Size()), dir+"/"+name, info.Mode())
+}
+
+// Wraps a local file being sent to the host, so that the upload is paced by
+// -bwlimit and reports its progress
+func (sesh *SSHSession) uploadReader(r io.Reader, name string, size int64) io.Reader {
+	return &progressReader{r: sesh.limiter.Reader(r), last: time.Now(), report: func(total int64) {
+		sesh.Remote.Status(fmt.Sprintf("Sent %s of %s of %s\n", formatKB(total/1024), formatKB(size/1024), name))
+	}}
+}
+
+// Sends the specified files to the specified directory on the remote host
+// via scp, preserving file modes.
+func (sesh *SSHSession) scpFiles(dir string, files []string) error {
 	session, err := sesh.connection.NewSession()
 	if err != nil {
 		return err
@@ -299,8 +701,8 @@ func (sesh *SSHSession) sendFiles(dir string, files []string) error {
 				return
 			}
 
-			fmt.Fprintf(stdin, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(file))
-			io.Copy(stdin, f)
+			fmt.Fprintf(stdin, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), filepath.Base(file))
+			io.Copy(stdin, sesh.uploadReader(f, filepath.Base(file), info.Size()))
 			fmt.Fprintf(stdin, "\x00")
 			f.Close()
 		}
@@ -308,7 +710,7 @@ func (sesh *SSHSession) sendFiles(dir string, files []string) error {
 		result <- nil
 	}()
 
-	out, err := session.CombinedOutput(fmt.Sprintf("/usr/bin/scp -tr %s", dir))
+	out, err := session.CombinedOutput("/usr/bin/scp -tr " + shellQuote(dir))
 	if err != nil {
 		log.Printf("File copy failed on %s [%s] remote: %s", sesh.Host, err.Error(), out)
 	}
@@ -322,3 +724,23 @@ func (sesh *SSHSession) sendFiles(dir string, files []string) error {
 
 	return err
 }
+
+// Has the remote host download the specified URLs into the specified
+// directory, verifying checksums where given.
+func (sesh *SSHSession) fetchURLs(dir string, urls []*FetchURL) error {
+	for _, fetch := range urls {
+		log.Printf("Fetching %s on %s", fetch.URL, sesh.Host)
+		session, err := sesh.connection.NewSession()
+		if err != nil {
+			return err
+		}
+
+		out, err := session.CombinedOutput(fmt.Sprintf("cd %s && %s", shellQuote(dir), fetch.script()))
+		session.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to fetch %s: %s: %s", fetch.URL, err.Error(), strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// A remote command's stdin, which tells the command when it is closed
+type fakeStdin struct {
+	written []byte
+	closed  chan struct{}
+}
+
+func (stdin *fakeStdin) Write(p []byte) (int, error) {
+	stdin.written = append(stdin.written, p...)
+	return len(p), nil
+}
+
+func (stdin *fakeStdin) Close() error {
+	close(stdin.closed)
+	return nil
+}
+
+func TestSudoClosesStdinWithoutAnswers(t *testing.T) {
+	noise, err := NewNoiseFilter(defaultNoise)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll := NewCaptureIOCollector()
+	remote := coll.NewRemote("host")
+	sesh := &SSHSession{Host: "host", Remote: remote, auth: &Auth{password: "secret"}}
+
+	// sudo prompts, then the command (like cat) waits for EOF on stdin
+	stdin := &fakeStdin{closed: make(chan struct{})}
+	out, stdout := io.Pipe()
+	go func() {
+		io.WriteString(stdout, "[sudo] password for jj: ")
+		io.WriteString(stdout, "\r\n")
+		select {
+		case <-stdin.closed:
+			io.WriteString(stdout, "read to EOF\r\n")
+		case <-time.After(5 * time.Second):
+			io.WriteString(stdout, "still waiting for stdin\r\n")
+		}
+
+		stdout.Close()
+	}()
+
+	go func() {
+		sesh.writePass(stdin, out, noise, nil)
+		remote.Done(nil)
+	}()
+
+	coll.Read()
+	if got := coll.Results[0].Stdout(); got != "read to EOF\r\n" {
+		t.Errorf("Got %q, wanted the command to read stdin to EOF", got)
+	}
+
+	if string(stdin.written) != "secret\r" {
+		t.Errorf("Wrote %q to stdin, wanted the password", stdin.written)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options from ~/.ssh/config that apply to one host
+type SSHHostOptions struct {
+	HostName     string
+	User         string
+	Port         int
+	IdentityFile string
+	ProxyJump    string
+}
+
+// The parts of an OpenSSH client config file that mesos-ssh understands
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+// A Host block and its options
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string]string
+}
+
+// Reads an OpenSSH client config file.  A missing file is the same as an
+// empty one.
+func NewSSHConfig(configPath string) (*SSHConfig, error) {
+	config := &SSHConfig{}
+	f, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	// Options before the first Host block apply to every host
+	block := &sshConfigBlock{patterns: []string{"*"}, options: make(map[string]string)}
+	config.blocks = append(config.blocks, block)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Keywords are separated from arguments by spaces or "="
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == '=' })
+		if len(fields) < 2 {
+			continue
+		}
+
+		keyword := strings.ToLower(fields[0])
+		switch keyword {
+		case "host":
+			block = &sshConfigBlock{patterns: fields[1:], options: make(map[string]string)}
+			config.blocks = append(config.blocks, block)
+		case "match":
+			// Not supported, so make sure its options don't apply anywhere
+			log.Printf("Ignoring Match block in %s", configPath)
+			block = &sshConfigBlock{options: make(map[string]string)}
+			config.blocks = append(config.blocks, block)
+		case "include":
+			log.Printf("Ignoring Include in %s", configPath)
+		default:
+			// The first value given for an option wins
+			if _, ok := block.options[keyword]; !ok {
+				block.options[keyword] = strings.Trim(fields[1], `"`)
+			}
+		}
+	}
+
+	return config, scanner.Err()
+}
+
+// Gets the options that apply to host.  As with ssh, the first value found
+// for each option is used.
+func (config *SSHConfig) Lookup(host string) *SSHHostOptions {
+	options := make(map[string]string)
+	for _, block := range config.blocks {
+		if !block.matches(host) {
+			continue
+		}
+
+		for keyword, value := range block.options {
+			if _, ok := options[keyword]; !ok {
+				options[keyword] = value
+			}
+		}
+	}
+
+	result := &SSHHostOptions{
+		HostName:     strings.Replace(options["hostname"], "%h", host, -1),
+		User:         options["user"],
+		IdentityFile: expandHome(options["identityfile"]),
+		ProxyJump:    options["proxyjump"],
+	}
+
+	if result.ProxyJump == "none" {
+		result.ProxyJump = ""
+	}
+
+	if port, err := strconv.Atoi(options["port"]); err == nil {
+		result.Port = port
+	}
+
+	return result
+}
+
+// Checks whether a Host block applies to host.  Negated patterns rule the
+// host out even if another pattern matches.
+func (block *sshConfigBlock) matches(host string) bool {
+	matched := false
+	for _, pattern := range block.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); ok {
+			if negated {
+				return false
+			}
+
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// Expands a leading ~ to the home directory
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return p
+	}
+
+	return filepath.Join(current.HomeDir, p[1:])
+}
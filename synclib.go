@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Names of the scripts and archive sync-lib sends
+const (
+	syncListScriptName  = "mesos-ssh-sync-list.sh"
+	syncApplyScriptName = "mesos-ssh-sync-apply.sh"
+	syncArchiveName     = "mesos-ssh-sync-lib.tar"
+)
+
+// Name of the file in the library directory that records its version
+const syncVersionFile = ".mesos-ssh-version"
+
+// Prints the library's version, then a sha256sum line for each file in it.
+// %s is the quoted remote directory.
+const syncListScript = `cd %s 2>/dev/null || exit 0
+echo "version $(cat ` + syncVersionFile + ` 2>/dev/null)"
+find . -type f ! -name ` + syncVersionFile + ` -exec sha256sum {} +
+`
+
+// Unpacks the changed files into %[1]s, removes the files in %[2]s and
+// records version %[3]s
+const syncApplyScript = `set -e
+dest=%[1]s
+mkdir -p "$dest"
+if [ -s ./` + syncArchiveName + ` ]; then
+	tar -xof ./` + syncArchiveName + ` -C "$dest"
+fi
+for f in %[2]s; do
+	rm -f -- "$dest/$f"
+done
+echo %[3]s > "$dest/` + syncVersionFile + `"
+`
+
+// Pushes a directory of helper scripts to the same path on every host,
+// sending only the files that differ
+func syncLibMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("sync-lib", flag.ExitOnError)
+	dest := fs.String("dest", "/opt/mesos-ssh/lib", "Remote directory to keep in sync")
+	keep := fs.Bool("keep-extra", false, "Leave remote files that aren't in the local directory")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 2 {
+		msgs.Fatalf("Usage: %s [OPTIONS] sync-lib <spec> <local dir> [-dest dir] [-keep-extra]", os.Args[0])
+	}
+
+	local, err := syncManifest(args[1])
+	if err != nil {
+		msgs.Fatalf("Failed to read %s: %s", args[1], err.Error())
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	if err := syncLib(hosts, local, args[1], *dest, *keep, msgs); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+}
+
+// Brings each host's copy of localDir up to date and prints the outcomes.  It
+// returns before the caller exits, so the temporary directory is always
+// removed.
+func syncLib(hosts []string, local map[string]string, localDir, dest string, keep bool, msgs *log.Logger) error {
+	version := syncVersion(local)
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		return err
+	}
+
+	// See what each host has now
+	listScript := filepath.Join(dir, syncListScriptName)
+	if err := ioutil.WriteFile(listScript, []byte(fmt.Sprintf(syncListScript, shellQuote(dest))), 0755); err != nil {
+		return err
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+syncListScriptName, flagSudo, flagPty, false, flagTimeout, []string{listScript})
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	// Hosts that need the same changes are updated together
+	outcomes := make(map[string][]string)
+	changes := make(map[string]*syncChange)
+	for _, result := range coll.Results {
+		if result.result != nil {
+			outcome := "(failed: " + result.result.Error() + ")"
+			outcomes[outcome] = append(outcomes[outcome], result.host)
+			continue
+		}
+
+		remoteVersion, remote := parseSyncList(result.Stdout())
+		change := diffSync(local, remote, keep)
+		if remoteVersion == version && change.empty() {
+			outcomes["up to date"] = append(outcomes["up to date"], result.host)
+			continue
+		}
+
+		key := change.key()
+		if changes[key] == nil {
+			changes[key] = change
+		}
+
+		changes[key].hosts = append(changes[key].hosts, result.host)
+	}
+
+	for _, change := range changes {
+		outcome := fmt.Sprintf("updated %d, removed %d", len(change.send), len(change.remove))
+		results, err := applySync(runner, change, localDir, dest, version, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if result.result != nil {
+				outcome := "(failed: " + result.result.Error() + ")"
+				outcomes[outcome] = append(outcomes[outcome], result.host)
+			} else if code := runner.ExitCode(result.host); code != 0 {
+				outcome := fmt.Sprintf("(failed: exit %d: %s)", code, firstLine(result.Stderr()))
+				outcomes[outcome] = append(outcomes[outcome], result.host)
+			} else {
+				outcomes[outcome] = append(outcomes[outcome], result.host)
+			}
+		}
+	}
+
+	fmt.Printf("Version %s of %s\n\n", version, localDir)
+	printHistogram(os.Stdout, "RESULT", outcomes)
+	runner.Finish()
+	return nil
+}
+
+// What one group of hosts needs, to match the local directory
+type syncChange struct {
+	send   []string
+	remove []string
+	hosts  []string
+}
+
+func (change *syncChange) empty() bool {
+	return len(change.send) == 0 && len(change.remove) == 0
+}
+
+func (change *syncChange) key() string {
+	return strings.Join(change.send, "\x00") + "\x01" + strings.Join(change.remove, "\x00")
+}
+
+// Sends the changed files to the group of hosts, and removes the others
+func applySync(runner *Runner, change *syncChange, localDir, dest, version, dir string) ([]*IOResult, error) {
+	group, err := ioutil.TempDir(dir, "group")
+	if err != nil {
+		return nil, err
+	}
+
+	archive := filepath.Join(group, syncArchiveName)
+	if err := writeSyncArchive(archive, localDir, change.send); err != nil {
+		return nil, fmt.Errorf("Failed to archive %s: %s", localDir, err.Error())
+	}
+
+	var removed []string
+	for _, name := range change.remove {
+		removed = append(removed, shellQuote(name))
+	}
+
+	script := filepath.Join(group, syncApplyScriptName)
+	contents := fmt.Sprintf(syncApplyScript, shellQuote(dest), strings.Join(removed, " "), shellQuote(version))
+	if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		return nil, err
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+syncApplyScriptName, flagSudo, flagPty, false, flagTimeout, []string{archive, script})
+	runner.Run(context.Background(), change.hosts, cmd, coll)
+	return coll.Results, nil
+}
+
+// Gets the sha256 of every regular file under dir, by slash-separated
+// relative path
+func syncManifest(dir string) (map[string]string, error) {
+	manifest := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+
+		manifest[filepath.ToSlash(rel)] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+
+	if err == nil && len(manifest) == 0 {
+		err = fmt.Errorf("No files to send")
+	}
+
+	return manifest, err
+}
+
+// Names a version of the library by the digest of its manifest
+func syncVersion(manifest map[string]string) string {
+	var names []string
+	for name := range manifest {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s  %s\n", manifest[name], name)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// Parses the output of syncListScript into the version and manifest
+func parseSyncList(output string) (string, map[string]string) {
+	version := ""
+	manifest := make(map[string]string)
+	for _, line := range strings.Split(strings.Replace(output, "\r", "", -1), "\n") {
+		if strings.HasPrefix(line, "version ") {
+			version = strings.TrimSpace(strings.TrimPrefix(line, "version "))
+		} else if fields := strings.SplitN(line, "  ", 2); len(fields) == 2 {
+			manifest[strings.TrimPrefix(fields[1], "./")] = fields[0]
+		}
+	}
+
+	return version, manifest
+}
+
+// Works out which files to send and which to remove
+func diffSync(local, remote map[string]string, keep bool) *syncChange {
+	change := &syncChange{}
+	for name, digest := range local {
+		if remote[name] != digest {
+			change.send = append(change.send, name)
+		}
+	}
+
+	if !keep {
+		for name := range remote {
+			if _, ok := local[name]; !ok {
+				change.remove = append(change.remove, name)
+			}
+		}
+	}
+
+	sort.Strings(change.send)
+	sort.Strings(change.remove)
+	return change
+}
+
+// Writes the named files from dir into a tar archive, keeping their modes
+func writeSyncArchive(path, dir string, names []string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+	archive := tar.NewWriter(out)
+	for _, name := range names {
+		if err := addToArchive(archive, dir, name); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+func addToArchive(archive *tar.Writer, dir, name string) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+
+	header.Name = name
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, file)
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the transient units -systemd-run starts
+const systemdUnitPrefix = "mesos-ssh-"
+
+// Name of the script units sends
+const unitsScriptName = "mesos-ssh-units.sh"
+
+// Lists the mesos-ssh units, or stops them if %[2]s is "stop".  %[1]s is
+// the unit pattern.
+const unitsScript = `if [ %[2]s = stop ]; then
+	for u in $(systemctl list-units --all --plain --no-legend %[1]s | awk '{ print $1 }'); do
+		systemctl stop "$u" && echo "$u stopped"
+	done
+else
+	systemctl list-units --all --plain --no-legend %[1]s | awk '{ print $1, $3 "/" $4 }'
+fi
+`
+
+// Wraps a command to run in a transient systemd unit, so it carries on if
+// the connection drops.  systemd-run waits for the unit and passes its
+// output and exit code through.  The command is quoted with double quotes,
+// which survive the quotes added for sudo.
+func systemdRun(unit string, properties []string, command string) string {
+	args := []string{"systemd-run", "--unit=" + unit, "--description=" + dquote("mesos-ssh run "+runID), "--wait", "--pipe", "--collect", "--quiet"}
+	for _, property := range properties {
+		args = append(args, "--property="+dquote(property))
+	}
+
+	args = append(args, "/bin/sh", "-c", dquote(command))
+	return strings.Join(args, " ")
+}
+
+// Quotes a string for the shell with double quotes
+func dquote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s) + `"`
+}
+
+// Lists or stops the units started by -systemd-run
+func unitsMain(args []string, msgs *log.Logger) {
+	fs := flag.NewFlagSet("units", flag.ExitOnError)
+	run := fs.String("run", "", "Only the unit from this run ID")
+	stop := fs.Bool("stop", false, "Stop the units rather than list them")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) != 1 {
+		msgs.Fatalf("Usage: %s [OPTIONS] units <spec> [-run id] [-stop]", os.Args[0])
+	}
+
+	hosts, err := GetHosts(flagMesos, args[0], msgs)
+	if err != nil {
+		msgs.Fatalf("Failed to find hosts: %s", err.Error())
+	}
+
+	pattern := systemdUnitPrefix + "*"
+	if *run != "" {
+		pattern = systemdUnitPrefix + *run + ".service"
+	}
+
+	action := "list"
+	if *stop {
+		action = "stop"
+	}
+
+	// The script is sent as a file, so it doesn't need quoting for sudo
+	dir, err := ioutil.TempDir("", "mesos-ssh")
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, unitsScriptName)
+	if err := ioutil.WriteFile(script, []byte(fmt.Sprintf(unitsScript, shellQuote(pattern), action)), 0755); err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	runner, err := NewRunner(msgs)
+	if err != nil {
+		msgs.Fatalf("%s", err.Error())
+	}
+
+	coll := NewCaptureIOCollector()
+	cmd := NewSSHCommand("/bin/sh ./"+unitsScriptName, flagSudo, flagPty, false, flagTimeout, []string{script})
+	runner.Run(context.Background(), hosts, cmd, coll)
+
+	// Group hosts by unit and state
+	units := make(map[string][]string)
+	for _, result := range coll.Results {
+		if result.result != nil {
+			key := "(failed: " + result.result.Error() + ")"
+			units[key] = append(units[key], result.host)
+			continue
+		}
+
+		found := false
+		for _, line := range strings.Split(strings.Replace(result.Stdout(), "\r", "", -1), "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, systemdUnitPrefix) {
+				units[line] = append(units[line], result.host)
+				found = true
+			}
+		}
+
+		if !found {
+			units["(none)"] = append(units["(none)"], result.host)
+		}
+	}
+
+	printHistogram(os.Stdout, "UNIT", units)
+	runner.Finish()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Command timeouts for particular hosts, in place of -timeout: from
+// -timeout-on rules first, then -timeout-masters and -timeout-agents
+type HostTimeouts struct {
+	rules    []*HostRules
+	timeouts []time.Duration
+
+	masters map[string]bool
+	agents  map[string]bool
+}
+
+// Parses -timeout-on rules of the form RULE=DURATION, and looks up the
+// masters and agents if they have timeouts of their own
+func NewHostTimeouts(msgs *log.Logger) (*HostTimeouts, error) {
+	timeouts := &HostTimeouts{}
+	for _, spec := range flagTimeoutOn {
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid -timeout-on %s, expected RULE=DURATION", spec)
+		}
+
+		timeout, err := time.ParseDuration(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -timeout-on %s: %s", spec, err.Error())
+		}
+
+		rules, err := NewHostRules("timeout-on", []string{spec[:i]}, msgs)
+		if err != nil {
+			return nil, err
+		}
+
+		timeouts.rules = append(timeouts.rules, rules)
+		timeouts.timeouts = append(timeouts.timeouts, timeout)
+	}
+
+	if flagMasterTime > 0 {
+		masters, err := getMasters()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find masters for -timeout-masters: %s", err.Error())
+		}
+
+		timeouts.masters = hostSet(masters)
+	}
+
+	if flagAgentTime > 0 {
+		agents, err := getAgents(flagMesos, msgs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find agents for -timeout-agents: %s", err.Error())
+		}
+
+		timeouts.agents = hostSet(filterAgents(agents, func(ag *MesosAgent) bool { return true }))
+	}
+
+	return timeouts, nil
+}
+
+// Gets the timeout for commands on host, or fallback if nothing overrides it
+func (timeouts *HostTimeouts) For(host string, fallback time.Duration) time.Duration {
+	for i, rules := range timeouts.rules {
+		if rules.Match(host) {
+			return timeouts.timeouts[i]
+		}
+	}
+
+	if timeouts.masters[host] {
+		return flagMasterTime
+	} else if timeouts.agents[host] {
+		return flagAgentTime
+	}
+
+	return fallback
+}
+
+// Makes a set of hosts, for looking them up
+func hostSet(hosts []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, host := range hosts {
+		set[host] = true
+	}
+
+	return set
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// TOS marking isn't available here
+func tosControl(tos int) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, fmt.Errorf("-dscp is not supported on this platform")
+}
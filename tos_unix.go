@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Makes a net.Dialer Control function that sets the IP TOS (or IPv6 traffic
+// class) byte on each socket
+func tosControl(tos int) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			} else {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			}
+		})
+
+		if controlErr != nil {
+			return controlErr
+		}
+
+		return err
+	}, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "context"
+
+// A connection to one remote host that commands can be run over.  SSHSession
+// is the real implementation; others can stand in for it in tests, dry runs
+// or other ways of reaching hosts, without changes to Runner or the
+// collectors.
+type Transport interface {
+	// Opens the connection to the host
+	Connect(ctx context.Context) error
+
+	// Sends local files into a directory on the host
+	PutFiles(ctx context.Context, dir string, files []string) error
+
+	// Runs cmd, including sending or fetching its files, with output going
+	// to the host's RemoteIO.  Returns the exit code, or -1 if the command
+	// did not complete.
+	RunCommand(ctx context.Context, cmd *SSHCommand) (int, error)
+
+	// Runs a short command and returns its stdout, without sending anything
+	// to the host's RemoteIO
+	Output(ctx context.Context, command string) (string, error)
+
+	// Closes the connection
+	Close() error
+}
+
+// A failure to reach a host: to connect to it, or to open the session a
+// command runs in.  Unlike other failures, these say nothing about the
+// command itself.
+type connectError struct {
+	err error
+}
+
+func (err *connectError) Error() string {
+	return err.err.Error()
+}
+
+// Checks whether err means the host couldn't be reached
+func isConnectError(err error) bool {
+	_, ok := err.(*connectError)
+	return ok
+}